@@ -2,7 +2,6 @@ module github.com/xxxbrian/surge-geosite
 
 go 1.24.4
 
-require (
-	github.com/oschwald/maxminddb-golang v1.13.1 // indirect
-	golang.org/x/sys v0.21.0 // indirect
-)
+require github.com/oschwald/maxminddb-golang v1.13.1
+
+require golang.org/x/sys v0.21.0 // indirect
@@ -4,38 +4,94 @@ package cache
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/sha256"
 	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
 
 // ZipCache holds the cached ZIP file data
 type ZipCache struct {
-	mu          sync.RWMutex
-	data        []byte
-	reader      *zip.Reader
-	etag        string
-	timestamp   time.Time
+	mu     sync.RWMutex
+	data   []byte
+	reader *zip.Reader
+	etag   string
+	// validators holds, per upstream URL, the validators of the response
+	// that URL last served the snapshot with. Mirrors send their own ETags,
+	// so each URL is only asked with its own.
+	validators map[string]Validators
+	timestamp  time.Time
+	// fetchedAt is when the snapshot was downloaded; timestamp also
+	// advances when upstream confirms it is unchanged.
+	fetchedAt   time.Time
 	ttl         time.Duration
 	persistPath string
+	// history holds the snapshots replaced by Set, newest first, for
+	// Rollback and Revision.
+	history     []*zipSnapshot
+	historySize int
+	// pin is the ref the snapshot is pinned to, empty when unpinned.
+	pin string
+}
+
+// Validators are the ETag and Last-Modified headers of a response, sent
+// back to the same URL to make the next request conditional.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// zipSnapshot is a past snapshot and its opened archive.
+type zipSnapshot struct {
+	persisted zipCachePersist
+	reader    *zip.Reader
+}
+
+// Snapshot describes a cached snapshot.
+type Snapshot struct {
+	ETag string `json:"etag"`
+	// Commit is the commit the archive was built from, when the archive
+	// records it as GitHub archives do.
+	Commit    string    `json:"commit,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Current   bool      `json:"current"`
 }
 
 // NewZipCache creates a new ZipCache with the specified TTL
 func NewZipCache(ttl time.Duration) *ZipCache {
 	return &ZipCache{
-		ttl: ttl,
+		ttl:         ttl,
+		historySize: 1,
 	}
 }
 
-// SetPersistPath enables on-disk persistence for the ZIP cache.
+// SetPersistPath enables on-disk persistence for the ZIP cache. Past
+// snapshots are kept in a directory next to it with a ".history" suffix.
 func (c *ZipCache) SetPersistPath(path string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.persistPath = path
 }
 
+// SetHistorySize sets how many past snapshots are kept besides the current
+// one. It must be called before LoadFromFile.
+func (c *ZipCache) SetHistorySize(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.historySize = max(n, 0)
+	if len(c.history) > c.historySize {
+		c.history = c.history[:c.historySize]
+	}
+}
+
 // Get returns the cached zip.Reader if valid, and the current ETag
 func (c *ZipCache) Get() (*zip.Reader, string, bool) {
 	c.mu.RLock()
@@ -64,23 +120,257 @@ func (c *ZipCache) GetAny() (*zip.Reader, string, bool) {
 	return c.reader, c.etag, true
 }
 
-// Set updates the cache with new data
+// Revision returns the current or a past snapshot whose ETag is rev, or
+// whose commit starts with rev when rev has at least 7 characters.
+func (c *ZipCache) Revision(rev string) (*zip.Reader, string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.reader != nil && matchRevision(rev, c.etag, c.reader) {
+		return c.reader, c.etag, true
+	}
+	for _, snap := range c.history {
+		if matchRevision(rev, snap.persisted.ETag, snap.reader) {
+			return snap.reader, snap.persisted.ETag, true
+		}
+	}
+	return nil, "", false
+}
+
+// Snapshots describes the current snapshot followed by the past ones,
+// newest first.
+func (c *ZipCache) Snapshots() []Snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var snapshots []Snapshot
+	if c.reader != nil {
+		snapshots = append(snapshots, Snapshot{ETag: c.etag, Commit: ArchiveCommit(c.reader), FetchedAt: c.fetchedAt, Current: true})
+	}
+	for _, snap := range c.history {
+		snapshots = append(snapshots, Snapshot{ETag: snap.persisted.ETag, Commit: ArchiveCommit(snap.reader), FetchedAt: snap.persisted.FetchedAt})
+	}
+	return snapshots
+}
+
+// matchRevision reports whether rev selects the snapshot with etag.
+func matchRevision(rev, etag string, reader *zip.Reader) bool {
+	if rev == etag {
+		return true
+	}
+	commit := ArchiveCommit(reader)
+	return len(rev) >= 7 && commit != "" && strings.HasPrefix(commit, strings.ToLower(rev))
+}
+
+// ArchiveCommit returns the commit recorded in the archive comment, as
+// GitHub does for repository archives, or "".
+func ArchiveCommit(reader *zip.Reader) string {
+	comment := strings.TrimSpace(reader.Comment)
+	if len(comment) != 40 {
+		return ""
+	}
+	if _, err := hex.DecodeString(comment); err != nil {
+		return ""
+	}
+	return strings.ToLower(comment)
+}
+
+// Set updates the cache with new data. A snapshot with a different ETag
+// becomes the newest past snapshot.
 func (c *ZipCache) Set(data []byte, etag string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.setLocked(data, etag, "", Validators{})
+}
+
+// SetFrom updates the cache with new data downloaded from url with the
+// validators v. Data identical to the current snapshot keeps its ETag, so
+// cached results stay valid, and only records the validators of url.
+func (c *ZipCache) SetFrom(data []byte, etag, url string, v Validators) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.setLocked(data, etag, url, v)
+}
+
+// SetUnlessPinned is SetFrom for a download of the followed upstream: it
+// reports false and keeps the snapshot when it is pinned.
+func (c *ZipCache) SetUnlessPinned(data []byte, etag, url string, v Validators) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pin != "" {
+		return false, nil
+	}
+	return true, c.setLocked(data, etag, url, v)
+}
+
+// SetPinned stores data like SetFrom and pins the snapshot to ref in the
+// same step, so no download of the followed upstream can replace it in
+// between.
+func (c *ZipCache) SetPinned(data []byte, etag, url string, v Validators, ref string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pinLocked(ref, func() error { return c.setLocked(data, etag, url, v) })
+}
+
+func (c *ZipCache) setLocked(data []byte, etag, url string, v Validators) error {
+	if c.reader != nil && bytes.Equal(data, c.data) {
+		if url != "" {
+			if c.validators == nil {
+				c.validators = make(map[string]Validators)
+			}
+			c.validators[url] = v
+		}
+		c.timestamp = time.Now()
+		return c.persistToFileLocked()
+	}
 
 	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
 	if err != nil {
 		return err
 	}
 
+	rotated := c.reader != nil && c.etag != etag
+	var replaced *zipSnapshot
+	if rotated {
+		replaced = &zipSnapshot{persisted: c.currentLocked(), reader: c.reader}
+		c.fetchedAt = time.Now()
+	} else if c.reader == nil {
+		c.fetchedAt = time.Now()
+	}
 	c.data = data
 	c.reader = reader
 	c.etag = etag
+	c.validators = nil
+	if url != "" {
+		c.validators = map[string]Validators{url: v}
+	}
 	c.timestamp = time.Now()
+	if !rotated {
+		return c.persistToFileLocked()
+	}
+	return c.pushHistoryLocked(replaced)
+}
+
+// Rollback makes the newest past snapshot current again, keeping the
+// replaced one as the newest past snapshot, and returns the restored ETag.
+func (c *ZipCache) Rollback() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.history) == 0 {
+		return "", errors.New("no previous snapshot")
+	}
+	etag := c.history[0].persisted.ETag
+	return etag, c.restoreLocked(etag)
+}
+
+// Restore makes the past snapshot with etag current, keeping the replaced
+// one as the newest past snapshot.
+func (c *ZipCache) Restore(etag string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.restoreLocked(etag)
+}
+
+// RestorePinned restores the past snapshot with etag like Restore and pins
+// it to ref in the same step.
+func (c *ZipCache) RestorePinned(etag, ref string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pinLocked(ref, func() error { return c.restoreLocked(etag) })
+}
+
+// pinLocked sets the pin to ref while update replaces and persists the
+// snapshot, keeping the previous pin if update fails.
+func (c *ZipCache) pinLocked(ref string, update func() error) error {
+	previous := c.pin
+	c.pin = ref
+	if err := update(); err != nil {
+		c.pin = previous
+		return err
+	}
+	return nil
+}
+
+func (c *ZipCache) restoreLocked(etag string) error {
+	i := slices.IndexFunc(c.history, func(snap *zipSnapshot) bool { return snap.persisted.ETag == etag })
+	if i < 0 {
+		return fmt.Errorf("no snapshot with ETag %s", etag)
+	}
+	target := c.history[i]
+	c.history = slices.Delete(c.history, i, i+1)
+
+	replaced := &zipSnapshot{persisted: c.currentLocked(), reader: c.reader}
+	c.data = target.persisted.Data
+	c.reader = target.reader
+	c.etag = target.persisted.ETag
+	c.validators = target.persisted.Validators
+	c.timestamp = target.persisted.Timestamp
+	c.fetchedAt = target.persisted.FetchedAt
+	return c.pushHistoryLocked(replaced)
+}
+
+// pushHistoryLocked makes snap the newest past snapshot, drops the oldest
+// ones beyond the history size and persists the result.
+func (c *ZipCache) pushHistoryLocked(snap *zipSnapshot) error {
+	c.history = slices.DeleteFunc(c.history, func(s *zipSnapshot) bool {
+		return s.persisted.ETag == snap.persisted.ETag || s.persisted.ETag == c.etag
+	})
+	if snap.reader != nil && snap.persisted.ETag != c.etag {
+		c.history = append([]*zipSnapshot{snap}, c.history...)
+	}
+	if len(c.history) > c.historySize {
+		c.history = c.history[:c.historySize]
+	}
 	if c.persistPath == "" {
 		return nil
 	}
+
+	dir := c.persistPath + ".history"
+	keep := make(map[string]bool, len(c.history))
+	for _, s := range c.history {
+		name := historyFileName(s.persisted.ETag)
+		keep[name] = true
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			continue
+		}
+		if err := writePersistFile(filepath.Join(dir, name), s.persisted); err != nil {
+			return err
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if !keep[entry.Name()] {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+	// The history directory replaces the single ".prev" file.
+	os.Remove(c.persistPath + ".prev")
+	return c.persistToFileLocked()
+}
+
+// historyFileName returns the file name of the past snapshot with etag.
+func historyFileName(etag string) string {
+	sum := sha256.Sum256([]byte(etag))
+	return hex.EncodeToString(sum[:8]) + ".gob"
+}
+
+// Pin returns the ref the snapshot is pinned to, or "" when unpinned.
+func (c *ZipCache) Pin() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.pin
+}
+
+// SetPin records the ref the snapshot is pinned to; "" unpins it. The pin is
+// persisted with the snapshot.
+func (c *ZipCache) SetPin(ref string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pin = ref
+	if c.persistPath == "" || c.reader == nil {
+		return nil
+	}
 	return c.persistToFileLocked()
 }
 
@@ -91,27 +381,63 @@ func (c *ZipCache) GetETag() string {
 	return c.etag
 }
 
+// Validators returns the validators url last served the current snapshot
+// with, and false when url never served it.
+func (c *ZipCache) Validators(url string) (Validators, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := c.validators[url]
+	return v, ok
+}
+
+// TouchUnlessPinned marks the current snapshot as fresh, as after upstream
+// confirmed it is unchanged. It reports false and leaves the snapshot alone
+// when it is pinned. The new time is not persisted: rewriting the archive
+// on every check costs more than the conditional request a restart may
+// repeat.
+func (c *ZipCache) TouchUnlessPinned() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pin != "" {
+		return false
+	}
+	if c.reader != nil {
+		c.timestamp = time.Now()
+	}
+	return true
+}
+
 type zipCachePersist struct {
-	Data      []byte
-	ETag      string
-	Timestamp time.Time
+	Data []byte
+	ETag string
+	// Validators is keyed by upstream URL.
+	Validators map[string]Validators
+	Timestamp  time.Time
+	FetchedAt  time.Time
+	Pin        string
 }
 
-// LoadFromFile restores cache data from disk if available.
+// currentLocked returns the current snapshot in its persisted form.
+func (c *ZipCache) currentLocked() zipCachePersist {
+	return zipCachePersist{
+		Data:       c.data,
+		ETag:       c.etag,
+		Validators: c.validators,
+		Timestamp:  c.timestamp,
+		FetchedAt:  c.fetchedAt,
+	}
+}
+
+// LoadFromFile restores cache data, and the past snapshots if present,
+// from disk.
 func (c *ZipCache) LoadFromFile(path string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	file, err := os.Open(path)
+	persisted, err := readPersistFile(path)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	var persisted zipCachePersist
-	if err := gob.NewDecoder(file).Decode(&persisted); err != nil {
-		return err
-	}
 
 	reader, err := zip.NewReader(bytes.NewReader(persisted.Data), int64(len(persisted.Data)))
 	if err != nil {
@@ -121,32 +447,92 @@ func (c *ZipCache) LoadFromFile(path string) error {
 	c.data = persisted.Data
 	c.reader = reader
 	c.etag = persisted.ETag
+	c.validators = persisted.Validators
 	c.timestamp = persisted.Timestamp
+	c.fetchedAt = fetchedAt(persisted)
+	c.pin = persisted.Pin
 	c.persistPath = path
+	c.history = loadHistory(path, c.etag, c.historySize)
 	return nil
 }
 
+// loadHistory reads the past snapshots stored next to path, including a
+// ".prev" file of older versions, newest first.
+func loadHistory(path, current string, size int) []*zipSnapshot {
+	files := []string{path + ".prev"}
+	entries, _ := os.ReadDir(path + ".history")
+	for _, entry := range entries {
+		files = append(files, filepath.Join(path+".history", entry.Name()))
+	}
+
+	var history []*zipSnapshot
+	seen := map[string]bool{current: true}
+	for _, file := range files {
+		persisted, err := readPersistFile(file)
+		if err != nil || seen[persisted.ETag] {
+			continue
+		}
+		reader, err := zip.NewReader(bytes.NewReader(persisted.Data), int64(len(persisted.Data)))
+		if err != nil {
+			log.Printf("Skipping unreadable snapshot %s: %v", file, err)
+			continue
+		}
+		seen[persisted.ETag] = true
+		persisted.FetchedAt = fetchedAt(persisted)
+		history = append(history, &zipSnapshot{persisted: persisted, reader: reader})
+	}
+	slices.SortFunc(history, func(a, b *zipSnapshot) int {
+		return b.persisted.FetchedAt.Compare(a.persisted.FetchedAt)
+	})
+	if len(history) > size {
+		history = history[:size]
+	}
+	return history
+}
+
+// fetchedAt returns the download time of a persisted snapshot; files
+// written before it was recorded only have the validation time.
+func fetchedAt(persisted zipCachePersist) time.Time {
+	if persisted.FetchedAt.IsZero() {
+		return persisted.Timestamp
+	}
+	return persisted.FetchedAt
+}
+
+func readPersistFile(path string) (zipCachePersist, error) {
+	var persisted zipCachePersist
+	file, err := os.Open(path)
+	if err != nil {
+		return persisted, err
+	}
+	defer file.Close()
+
+	err = gob.NewDecoder(file).Decode(&persisted)
+	return persisted, err
+}
+
 func (c *ZipCache) persistToFileLocked() error {
 	if c.persistPath == "" {
 		return nil
 	}
+	persisted := c.currentLocked()
+	persisted.Pin = c.pin
+	return writePersistFile(c.persistPath, persisted)
+}
 
-	if err := os.MkdirAll(filepath.Dir(c.persistPath), 0o755); err != nil {
+func writePersistFile(path string, persisted zipCachePersist) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return err
 	}
 
-	tmpPath := c.persistPath + ".tmp"
+	tmpPath := path + ".tmp"
 	file, err := os.Create(tmpPath)
 	if err != nil {
 		return err
 	}
 
 	enc := gob.NewEncoder(file)
-	err = enc.Encode(zipCachePersist{
-		Data:      c.data,
-		ETag:      c.etag,
-		Timestamp: c.timestamp,
-	})
+	err = enc.Encode(persisted)
 	closeErr := file.Close()
 	if err != nil {
 		os.Remove(tmpPath) // cleanup on failure
@@ -157,7 +543,7 @@ func (c *ZipCache) persistToFileLocked() error {
 		return closeErr
 	}
 
-	return os.Rename(tmpPath, c.persistPath)
+	return os.Rename(tmpPath, path)
 }
 
 // ResultCache caches the conversion results
@@ -169,6 +555,7 @@ type ResultCache struct {
 
 type cacheEntry struct {
 	value     string
+	headers   map[string]string
 	timestamp time.Time
 	etag      string
 }
@@ -183,29 +570,41 @@ func NewResultCache(ttl time.Duration) *ResultCache {
 
 // Get retrieves a cached result if valid
 func (c *ResultCache) Get(key, etag string) (string, bool) {
+	value, _, ok := c.GetWithHeaders(key, etag)
+	return value, ok
+}
+
+// GetWithHeaders retrieves a cached result and the response headers stored with it.
+func (c *ResultCache) GetWithHeaders(key, etag string) (string, map[string]string, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	entry, ok := c.results[key]
 	if !ok {
-		return "", false
+		return "", nil, false
 	}
 
 	// Check if ETag matches and not expired
 	if entry.etag != etag || time.Since(entry.timestamp) > c.ttl {
-		return "", false
+		return "", nil, false
 	}
 
-	return entry.value, true
+	return entry.value, entry.headers, true
 }
 
 // Set stores a result in the cache
 func (c *ResultCache) Set(key, value, etag string) {
+	c.SetWithHeaders(key, value, etag, nil)
+}
+
+// SetWithHeaders stores a result together with response headers describing it.
+func (c *ResultCache) SetWithHeaders(key, value, etag string, headers map[string]string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	c.results[key] = &cacheEntry{
 		value:     value,
+		headers:   headers,
 		timestamp: time.Now(),
 		etag:      etag,
 	}
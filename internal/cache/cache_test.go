@@ -0,0 +1,216 @@
+package cache_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xxxbrian/surge-geosite/internal/cache"
+)
+
+func emptyZip(t *testing.T, name string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if _, err := zw.Create(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestZipCacheRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zip-cache.gob")
+	c := cache.NewZipCache(time.Hour)
+	c.SetPersistPath(path)
+
+	if _, err := c.Rollback(); err == nil {
+		t.Error("Rollback() without a previous snapshot succeeded")
+	}
+	if err := c.Set(emptyZip(t, "a"), "etag-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(emptyZip(t, "b"), "etag-b"); err != nil {
+		t.Fatal(err)
+	}
+	// Storing the same snapshot again keeps etag-a as the previous one.
+	if err := c.Set(emptyZip(t, "b"), "etag-b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetPin("v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := cache.NewZipCache(time.Hour)
+	if err := restored.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if restored.GetETag() != "etag-b" || restored.Pin() != "v1" {
+		t.Errorf("LoadFromFile() = %s pinned %q, want etag-b pinned v1", restored.GetETag(), restored.Pin())
+	}
+	etag, err := restored.Rollback()
+	if err != nil || etag != "etag-a" {
+		t.Fatalf("Rollback() = %s, %v, want etag-a", etag, err)
+	}
+	reader, _, ok := restored.GetAny()
+	if !ok || reader.File[0].Name != "a" {
+		t.Error("Rollback() did not restore the previous archive")
+	}
+	if etag, err := restored.Rollback(); err != nil || etag != "etag-b" {
+		t.Errorf("second Rollback() = %s, %v, want etag-b", etag, err)
+	}
+}
+
+func TestZipCacheHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zip-cache.gob")
+	c := cache.NewZipCache(time.Hour)
+	c.SetPersistPath(path)
+	c.SetHistorySize(2)
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if err := c.Set(emptyZip(t, name), "etag-"+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var commented bytes.Buffer
+	zw := zip.NewWriter(&commented)
+	if err := zw.SetComment("0123456789abcdef0123456789abcdef01234567"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(commented.Bytes(), "etag-e"); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := cache.NewZipCache(time.Hour)
+	restored.SetHistorySize(2)
+	if err := restored.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	var etags []string
+	for _, snap := range restored.Snapshots() {
+		etags = append(etags, snap.ETag)
+	}
+	if got := strings.Join(etags, ","); got != "etag-e,etag-d,etag-c" {
+		t.Errorf("Snapshots() = %s, want etag-e,etag-d,etag-c", got)
+	}
+
+	if reader, _, ok := restored.Revision("etag-c"); !ok || reader.File[0].Name != "c" {
+		t.Error("Revision(etag-c) did not return the past archive")
+	}
+	if _, _, ok := restored.Revision("etag-b"); ok {
+		t.Error("Revision(etag-b) returned a snapshot beyond the history size")
+	}
+	if _, etag, ok := restored.Revision("0123456"); !ok || etag != "etag-e" {
+		t.Errorf("Revision(commit prefix) = %s, %v, want etag-e", etag, ok)
+	}
+
+	if err := restored.Restore("etag-c"); err != nil {
+		t.Fatal(err)
+	}
+	if restored.GetETag() != "etag-c" {
+		t.Errorf("Restore() made %s current, want etag-c", restored.GetETag())
+	}
+	if snaps := restored.Snapshots(); len(snaps) != 3 || snaps[1].ETag != "etag-e" {
+		t.Errorf("Snapshots() after Restore = %+v, want etag-e as newest past snapshot", snaps)
+	}
+}
+
+func TestZipCacheUnlessPinned(t *testing.T) {
+	c := cache.NewZipCache(time.Nanosecond)
+	if err := c.Set(emptyZip(t, "a"), "etag-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetPin("v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := c.SetUnlessPinned(emptyZip(t, "b"), "etag-b", "", cache.Validators{}); ok || err != nil {
+		t.Errorf("SetUnlessPinned() while pinned = %v, %v", ok, err)
+	}
+	if c.TouchUnlessPinned() {
+		t.Error("TouchUnlessPinned() while pinned = true")
+	}
+	if c.GetETag() != "etag-a" {
+		t.Errorf("pinned snapshot replaced by %s", c.GetETag())
+	}
+
+	if err := c.SetPin(""); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.SetUnlessPinned(emptyZip(t, "b"), "etag-b", "", cache.Validators{}); !ok || err != nil || c.GetETag() != "etag-b" {
+		t.Errorf("SetUnlessPinned() = %v, %v, current %s, want etag-b", ok, err, c.GetETag())
+	}
+}
+
+func TestZipCacheTouchDoesNotPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zip-cache.gob")
+	c := cache.NewZipCache(time.Hour)
+	c.SetPersistPath(path)
+	if err := c.Set(emptyZip(t, "a"), "etag-a"); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Time{}, before.ModTime().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.TouchUnlessPinned() {
+		t.Fatal("TouchUnlessPinned() = false")
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.ModTime().Equal(before.ModTime().Add(-time.Hour)) {
+		t.Error("TouchUnlessPinned() rewrote the persisted snapshot")
+	}
+}
+
+func TestZipCachePinned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zip-cache.gob")
+	c := cache.NewZipCache(time.Hour)
+	c.SetPersistPath(path)
+	if err := c.Set(emptyZip(t, "a"), "etag-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.SetPinned([]byte("not a zip"), "etag-x", "", cache.Validators{}, "v0"); err == nil || c.Pin() != "" {
+		t.Errorf("SetPinned() of an invalid archive = %v, pinned %q", err, c.Pin())
+	}
+	if err := c.SetPinned(emptyZip(t, "b"), "etag-b", "", cache.Validators{}, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := c.SetUnlessPinned(emptyZip(t, "c"), "etag-c", "", cache.Validators{}); ok {
+		t.Error("SetUnlessPinned() replaced the pinned snapshot")
+	}
+
+	restored := cache.NewZipCache(time.Hour)
+	if err := restored.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if restored.GetETag() != "etag-b" || restored.Pin() != "v1" {
+		t.Errorf("LoadFromFile() = %s pinned %q, want etag-b pinned v1", restored.GetETag(), restored.Pin())
+	}
+
+	if err := restored.RestorePinned("etag-a", "snapshot:etag-a"); err != nil {
+		t.Fatal(err)
+	}
+	if restored.GetETag() != "etag-a" || restored.Pin() != "snapshot:etag-a" {
+		t.Errorf("RestorePinned() = %s pinned %q", restored.GetETag(), restored.Pin())
+	}
+	if err := restored.RestorePinned("etag-x", "snapshot:etag-x"); err == nil || restored.Pin() != "snapshot:etag-a" {
+		t.Errorf("RestorePinned() of an unknown snapshot = %v, pinned %q", err, restored.Pin())
+	}
+}
@@ -0,0 +1,34 @@
+// Package converter handles the conversion of v2fly domain list format to ruleset formats.
+package converter
+
+// ParseCombined parses several upstream lists into one item list. Each list
+// is introduced by an "# include:name" comment, as if a list included them
+// all, and rules already contributed by an earlier list are dropped.
+func (c *Converter) ParseCombined(names []string, filter Filter) ([]Item, error) {
+	seen := make(map[ruleKey]bool)
+	var items []Item
+
+	for _, name := range names {
+		subItems, err := c.expandInclude(name, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, Item{
+			Kind:    ItemComment,
+			Comment: "# include:" + name,
+		})
+		for _, item := range subItems {
+			if item.Kind == ItemRule && item.Rule != nil {
+				key := keyOf(*item.Rule)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
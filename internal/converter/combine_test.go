@@ -0,0 +1,39 @@
+package converter_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/xxxbrian/surge-geosite/internal/converter"
+)
+
+func TestParseCombined(t *testing.T) {
+	files := map[string]string{
+		"a": "domain:a.com\ndomain:shared.com @cn",
+		"b": "domain:shared.com\nfull:b.com @cn",
+	}
+	getter := converter.ListReaderFunc(func(name string) (string, error) {
+		content, ok := files[name]
+		if !ok {
+			return "", fmt.Errorf("file not found: %s", name)
+		}
+		return content, nil
+	})
+
+	items, err := converter.NewConverter(getter).ParseCombined([]string{"a", "b"}, converter.Filter{})
+	if err != nil {
+		t.Fatalf("ParseCombined failed: %v", err)
+	}
+	var got []string
+	for _, item := range items {
+		if item.Kind == converter.ItemRule {
+			got = append(got, item.Rule.Value)
+		} else {
+			got = append(got, item.Comment)
+		}
+	}
+	want := []string{"# include:a", "a.com", "shared.com", "# include:b", "b.com"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("ParseCombined() = %v, want %v", got, want)
+	}
+}
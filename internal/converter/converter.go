@@ -1,47 +1,43 @@
 // Package converter handles the conversion of v2fly domain list format to Surge ruleset format.
 package converter
 
-import (
-	"archive/zip"
-)
+// maxIncludeDepth bounds include nesting so malformed upstream data cannot
+// exhaust the stack.
+const maxIncludeDepth = 32
 
 // Converter handles rule conversion
 type Converter struct {
-	zipReader  *zip.Reader
-	fileGetter func(reader *zip.Reader, name string) (string, error)
+	lists        ListReader
+	includeCache *IncludeCache
+	etag         string
+	memo         map[string][]Item
+	stack        []string
 }
 
-// NewConverter creates a new Converter
-func NewConverter(zipReader *zip.Reader, fileGetter func(reader *zip.Reader, name string) (string, error)) *Converter {
-	return &Converter{
-		zipReader:  zipReader,
-		fileGetter: fileGetter,
-	}
+// ListReader reads upstream lists by name.
+type ListReader interface {
+	ReadList(name string) (string, error)
 }
 
-// Convert converts upstream content to Surge ruleset format
-func (c *Converter) Convert(upstreamContent string, filter string) (string, error) {
-	items, err := c.Parse(upstreamContent, filter)
-	if err != nil {
-		return "", err
-	}
-	return RenderSurge(items), nil
+// ListReaderFunc adapts a function to a ListReader.
+type ListReaderFunc func(name string) (string, error)
+
+// ReadList calls fn(name).
+func (fn ListReaderFunc) ReadList(name string) (string, error) {
+	return fn(name)
 }
 
-// ConvertMihomo converts upstream content to Mihomo ruleset format.
-func (c *Converter) ConvertMihomo(upstreamContent string, filter string) (string, error) {
-	items, err := c.Parse(upstreamContent, filter)
-	if err != nil {
-		return "", err
+// NewConverter creates a new Converter resolving lists and includes with lists
+func NewConverter(lists ListReader) *Converter {
+	return &Converter{
+		lists: lists,
+		memo:  make(map[string][]Item),
 	}
-	return RenderMihomo(items), nil
 }
 
-// ConvertEgern converts upstream content to Egern ruleset YAML.
-func (c *Converter) ConvertEgern(upstreamContent string, filter string) (string, error) {
-	items, err := c.Parse(upstreamContent, filter)
-	if err != nil {
-		return "", err
-	}
-	return RenderEgern(items), nil
+// SetIncludeCache shares parsed include results across conversions of the
+// upstream snapshot identified by etag.
+func (c *Converter) SetIncludeCache(ic *IncludeCache, etag string) {
+	c.includeCache = ic
+	c.etag = etag
 }
@@ -0,0 +1,198 @@
+// Package converter handles the conversion of v2fly domain list format to ruleset formats.
+package converter
+
+import (
+	"strconv"
+	"strings"
+)
+
+// DNSOptions configures the DNS-layer outputs.
+type DNSOptions struct {
+	// Upstream forwards matching domains to this resolver instead of blocking them.
+	Upstream string
+	// Sinkhole is the address blocked domains resolve to. When empty,
+	// formats that can answer NXDOMAIN do so.
+	Sinkhole string
+}
+
+func init() {
+	RegisterRenderer(NewRenderer("adguard", ContentTypeText,
+		supportOf(RuleDomainSuffix, RuleDomain, RuleDomainKeyword, RuleDomainRegex), dnsRenderFunc(RenderAdGuard)))
+	// dnsmasq and SmartDNS domain sets always match subdomains.
+	approximateDomain := map[RuleKind]Support{RuleDomainSuffix: Native, RuleDomain: Approximate}
+	RegisterRenderer(NewRenderer("dnsmasq", ContentTypeText, approximateDomain, dnsRenderFunc(RenderDnsmasq)))
+	RegisterRenderer(NewRenderer("smartdns", ContentTypeText, approximateDomain, dnsRenderFunc(RenderSmartDNS)))
+	// unbound local zones cover subdomains, so without a sinkhole full
+	// domains also block their subdomains.
+	RegisterRenderer(NewRenderer("unbound", ContentTypeText,
+		map[RuleKind]Support{RuleDomainSuffix: Native, RuleDomain: Approximate}, dnsRenderFunc(RenderUnbound)))
+	// hosts entries only match the exact name.
+	RegisterRenderer(NewRenderer("hosts", ContentTypeText,
+		map[RuleKind]Support{RuleDomainSuffix: Approximate, RuleDomain: Native}, dnsRenderFunc(RenderHosts)))
+}
+
+func dnsRenderFunc(render func(items []Item, opts DNSOptions) string) RenderFunc {
+	return func(items []Item, opts RenderOptions) (string, error) {
+		return render(items, opts.DNS), nil
+	}
+}
+
+// dnsRenderer renders one rule into zero or more lines, reporting false when
+// the format cannot express the rule.
+type dnsRenderer func(rule Rule) ([]string, bool)
+
+// RenderAdGuard renders parsed items into AdGuard Home / ABP filter syntax,
+// or into AdGuard Home upstream syntax when opts.Upstream is set.
+func RenderAdGuard(items []Item, opts DNSOptions) string {
+	if opts.Upstream != "" {
+		return renderDNS(items, "#", nil, func(rule Rule) ([]string, bool) {
+			switch rule.Kind {
+			case RuleDomainSuffix, RuleDomain:
+				return []string{"[/" + rule.Value + "/]" + opts.Upstream}, true
+			}
+			return nil, false
+		})
+	}
+
+	modifier := ""
+	if opts.Sinkhole != "" {
+		modifier = "$dnsrewrite=" + opts.Sinkhole
+	}
+	return renderDNS(items, "!", nil, func(rule Rule) ([]string, bool) {
+		switch rule.Kind {
+		case RuleDomainSuffix:
+			return []string{"||" + rule.Value + "^" + modifier}, true
+		case RuleDomain:
+			return []string{"|" + rule.Value + "^" + modifier}, true
+		case RuleDomainKeyword:
+			// Rules without anchors match any hostname containing them.
+			return []string{rule.Value + modifier}, true
+		case RuleDomainRegex:
+			return []string{"/" + rule.Value + "/" + modifier}, true
+		}
+		return nil, false
+	})
+}
+
+// RenderDnsmasq renders parsed items into dnsmasq configuration. dnsmasq
+// always matches subdomains, so full domains are treated as suffixes.
+func RenderDnsmasq(items []Item, opts DNSOptions) string {
+	return renderDNS(items, "#", nil, func(rule Rule) ([]string, bool) {
+		switch rule.Kind {
+		case RuleDomainSuffix, RuleDomain:
+			if opts.Upstream != "" {
+				return []string{"server=/" + rule.Value + "/" + opts.Upstream}, true
+			}
+			return []string{"address=/" + rule.Value + "/" + opts.Sinkhole}, true
+		}
+		return nil, false
+	})
+}
+
+// RenderSmartDNS renders parsed items into a SmartDNS domain-set file, or
+// into nameserver / address rules when opts.Upstream or opts.Sinkhole is set.
+func RenderSmartDNS(items []Item, opts DNSOptions) string {
+	return renderDNS(items, "#", nil, func(rule Rule) ([]string, bool) {
+		switch rule.Kind {
+		case RuleDomainSuffix, RuleDomain:
+			switch {
+			case opts.Upstream != "":
+				return []string{"nameserver /" + rule.Value + "/" + opts.Upstream}, true
+			case opts.Sinkhole != "":
+				return []string{"address /" + rule.Value + "/" + opts.Sinkhole}, true
+			}
+			return []string{rule.Value}, true
+		}
+		return nil, false
+	})
+}
+
+// RenderUnbound renders parsed items into unbound configuration: forward
+// zones when opts.Upstream is set, otherwise local zones answering NXDOMAIN
+// or the sinkhole address. Only the sinkhole answers full domains exactly;
+// NXDOMAIN zones always cover subdomains.
+func RenderUnbound(items []Item, opts DNSOptions) string {
+	if opts.Upstream != "" {
+		return renderDNS(items, "#", nil, func(rule Rule) ([]string, bool) {
+			switch rule.Kind {
+			case RuleDomainSuffix, RuleDomain:
+				return []string{
+					"forward-zone:",
+					"    name: " + strconv.Quote(rule.Value+"."),
+					"    forward-addr: " + opts.Upstream,
+				}, true
+			}
+			return nil, false
+		})
+	}
+
+	record := "A"
+	if strings.Contains(opts.Sinkhole, ":") {
+		record = "AAAA"
+	}
+	localData := func(domain string) string {
+		return "    local-data: " + strconv.Quote(domain+". "+record+" "+opts.Sinkhole)
+	}
+	return renderDNS(items, "#", []string{"server:"}, func(rule Rule) ([]string, bool) {
+		zone := "    local-zone: " + strconv.Quote(rule.Value+".")
+		switch rule.Kind {
+		case RuleDomainSuffix:
+			if opts.Sinkhole != "" {
+				return []string{zone + " redirect", localData(rule.Value)}, true
+			}
+			return []string{zone + " always_nxdomain"}, true
+		case RuleDomain:
+			if opts.Sinkhole != "" {
+				// local-data outside a zone only answers the exact name.
+				return []string{localData(rule.Value)}, true
+			}
+			return []string{zone + " always_nxdomain"}, true
+		}
+		return nil, false
+	})
+}
+
+// RenderHosts renders parsed items into hosts file format. Hosts entries
+// only match the exact name, so suffix rules do not cover subdomains.
+func RenderHosts(items []Item, opts DNSOptions) string {
+	sinkhole := opts.Sinkhole
+	if sinkhole == "" {
+		sinkhole = "0.0.0.0"
+	}
+	return renderDNS(items, "#", nil, func(rule Rule) ([]string, bool) {
+		switch rule.Kind {
+		case RuleDomainSuffix, RuleDomain:
+			return []string{sinkhole + " " + rule.Value}, true
+		}
+		return nil, false
+	})
+}
+
+// renderDNS renders every rule with render and lists the rules the format
+// cannot express in a trailing comment block.
+func renderDNS(items []Item, commentPrefix string, header []string, render dnsRenderer) string {
+	lines := append([]string{}, header...)
+	var unsupported []Rule
+
+	for _, item := range items {
+		if item.Kind != ItemRule || item.Rule == nil {
+			continue
+		}
+		rendered, ok := render(*item.Rule)
+		if !ok {
+			unsupported = append(unsupported, *item.Rule)
+			continue
+		}
+		lines = append(lines, rendered...)
+	}
+
+	if len(unsupported) > 0 {
+		lines = append(lines, "", commentPrefix+" "+strconv.Itoa(len(unsupported))+" rules not expressible in this format:")
+		for _, rule := range unsupported {
+			rule.Comment = ""
+			lines = append(lines, commentPrefix+" "+mihomoSyntax.render(rule))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
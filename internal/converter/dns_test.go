@@ -0,0 +1,55 @@
+package converter_test
+
+import (
+	"testing"
+
+	"github.com/xxxbrian/surge-geosite/internal/converter"
+)
+
+func TestRenderDNS(t *testing.T) {
+	items, err := converter.NewConverter(nil).Parse("domain:ads.example\nfull:track.example.net\nkeyword:doubleclick\nregexp:^ad[0-9]+\\.example\\.org$\n", converter.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		render func([]converter.Item, converter.DNSOptions) string
+		opts   converter.DNSOptions
+		want   string
+	}{
+		{"adguard", converter.RenderAdGuard, converter.DNSOptions{},
+			"||ads.example^\n|track.example.net^\ndoubleclick\n/^ad[0-9]+\\.example\\.org$/"},
+		{"adguard sinkhole", converter.RenderAdGuard, converter.DNSOptions{Sinkhole: "0.0.0.0"},
+			"||ads.example^$dnsrewrite=0.0.0.0\n|track.example.net^$dnsrewrite=0.0.0.0\ndoubleclick$dnsrewrite=0.0.0.0\n/^ad[0-9]+\\.example\\.org$/$dnsrewrite=0.0.0.0"},
+		{"adguard upstream", converter.RenderAdGuard, converter.DNSOptions{Upstream: "tls://1.1.1.1"},
+			"[/ads.example/]tls://1.1.1.1\n[/track.example.net/]tls://1.1.1.1\n\n# 2 rules not expressible in this format:\n# DOMAIN-KEYWORD,doubleclick\n# DOMAIN-REGEX,^ad[0-9]+\\.example\\.org$"},
+		{"dnsmasq", converter.RenderDnsmasq, converter.DNSOptions{},
+			"address=/ads.example/\naddress=/track.example.net/\n\n# 2 rules not expressible in this format:\n# DOMAIN-KEYWORD,doubleclick\n# DOMAIN-REGEX,^ad[0-9]+\\.example\\.org$"},
+		{"dnsmasq upstream", converter.RenderDnsmasq, converter.DNSOptions{Upstream: "127.0.0.1#5353"},
+			"server=/ads.example/127.0.0.1#5353\nserver=/track.example.net/127.0.0.1#5353\n\n# 2 rules not expressible in this format:\n# DOMAIN-KEYWORD,doubleclick\n# DOMAIN-REGEX,^ad[0-9]+\\.example\\.org$"},
+		{"smartdns", converter.RenderSmartDNS, converter.DNSOptions{},
+			"ads.example\ntrack.example.net\n\n# 2 rules not expressible in this format:\n# DOMAIN-KEYWORD,doubleclick\n# DOMAIN-REGEX,^ad[0-9]+\\.example\\.org$"},
+		{"smartdns sinkhole", converter.RenderSmartDNS, converter.DNSOptions{Sinkhole: "0.0.0.0"},
+			"address /ads.example/0.0.0.0\naddress /track.example.net/0.0.0.0\n\n# 2 rules not expressible in this format:\n# DOMAIN-KEYWORD,doubleclick\n# DOMAIN-REGEX,^ad[0-9]+\\.example\\.org$"},
+		{"unbound", converter.RenderUnbound, converter.DNSOptions{},
+			"server:\n    local-zone: \"ads.example.\" always_nxdomain\n    local-zone: \"track.example.net.\" always_nxdomain\n\n# 2 rules not expressible in this format:\n# DOMAIN-KEYWORD,doubleclick\n# DOMAIN-REGEX,^ad[0-9]+\\.example\\.org$"},
+		{"unbound sinkhole", converter.RenderUnbound, converter.DNSOptions{Sinkhole: "::"},
+			"server:\n    local-zone: \"ads.example.\" redirect\n    local-data: \"ads.example. AAAA ::\"\n    local-data: \"track.example.net. AAAA ::\"\n\n# 2 rules not expressible in this format:\n# DOMAIN-KEYWORD,doubleclick\n# DOMAIN-REGEX,^ad[0-9]+\\.example\\.org$"},
+		{"unbound upstream", converter.RenderUnbound, converter.DNSOptions{Upstream: "9.9.9.9@853#dns.quad9.net"},
+			"forward-zone:\n    name: \"ads.example.\"\n    forward-addr: 9.9.9.9@853#dns.quad9.net\nforward-zone:\n    name: \"track.example.net.\"\n    forward-addr: 9.9.9.9@853#dns.quad9.net\n\n# 2 rules not expressible in this format:\n# DOMAIN-KEYWORD,doubleclick\n# DOMAIN-REGEX,^ad[0-9]+\\.example\\.org$"},
+		{"hosts", converter.RenderHosts, converter.DNSOptions{},
+			"0.0.0.0 ads.example\n0.0.0.0 track.example.net\n\n# 2 rules not expressible in this format:\n# DOMAIN-KEYWORD,doubleclick\n# DOMAIN-REGEX,^ad[0-9]+\\.example\\.org$"},
+	}
+	for _, tt := range tests {
+		if got := tt.render(items, tt.opts); got != tt.want {
+			t.Errorf("%s =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+
+	// Without a sinkhole unbound blocks full domains with zones that also
+	// cover their subdomains.
+	if r, _ := converter.LookupRenderer("unbound"); r.Support(converter.RuleDomain) != converter.Approximate {
+		t.Errorf("unbound full-domain support = %v, want Approximate", r.Support(converter.RuleDomain))
+	}
+}
@@ -0,0 +1,171 @@
+// Package converter handles the conversion of v2fly domain list format to ruleset formats.
+package converter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Filter is a parsed attribute expression applied to the attributes of each rule.
+//
+// Terms are separated by "@" and must all match (AND). A term may list
+// alternatives separated by "|", any of which may match (OR). An attribute
+// prefixed with "!" matches rules that do not carry it. For example
+// "cn@ads" selects rules tagged with both @cn and @ads, "!cn" selects
+// everything not tagged @cn and "cn|ads" selects rules tagged with either.
+type Filter struct {
+	terms [][]attrMatch
+}
+
+type attrMatch struct {
+	attr   string
+	negate bool
+}
+
+// ParseFilter parses an attribute expression. A leading "@" is optional and
+// an empty expression yields a filter matching every rule.
+func ParseFilter(expr string) (Filter, error) {
+	expr = strings.ToLower(strings.TrimSpace(expr))
+	var f Filter
+	for _, term := range strings.Split(expr, "@") {
+		if term == "" {
+			continue
+		}
+		var alternatives []attrMatch
+		for _, alt := range strings.Split(term, "|") {
+			negate := strings.HasPrefix(alt, "!")
+			attr := strings.TrimPrefix(alt, "!")
+			if !isValidAttr(attr) {
+				return Filter{}, fmt.Errorf("invalid attribute %q in filter %q", alt, expr)
+			}
+			alternatives = append(alternatives, attrMatch{attr: attr, negate: negate})
+		}
+		f.terms = append(f.terms, alternatives)
+	}
+	return f, nil
+}
+
+// IsEmpty reports whether the filter matches every rule.
+func (f Filter) IsEmpty() bool {
+	return len(f.terms) == 0
+}
+
+// Match reports whether a rule carrying attrs satisfies the filter.
+func (f Filter) Match(attrs []string) bool {
+	for _, term := range f.terms {
+		if !matchAny(term, attrs) {
+			return false
+		}
+	}
+	return true
+}
+
+// And returns a filter matching rules that satisfy both f and other.
+func (f Filter) And(other Filter) Filter {
+	terms := make([][]attrMatch, 0, len(f.terms)+len(other.terms))
+	terms = append(terms, f.terms...)
+	terms = append(terms, other.terms...)
+	return Filter{terms: terms}
+}
+
+// String returns the normalized expression, suitable for use as a cache key.
+func (f Filter) String() string {
+	terms := make([]string, 0, len(f.terms))
+	seen := make(map[string]bool, len(f.terms))
+	for _, term := range f.terms {
+		alternatives := make([]string, 0, len(term))
+		for _, m := range term {
+			alternatives = append(alternatives, m.String())
+		}
+		sort.Strings(alternatives)
+		s := strings.Join(alternatives, "|")
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		terms = append(terms, s)
+	}
+	sort.Strings(terms)
+	return strings.Join(terms, "@")
+}
+
+func (m attrMatch) String() string {
+	if m.negate {
+		return "!" + m.attr
+	}
+	return m.attr
+}
+
+func (m attrMatch) match(attrs []string) bool {
+	for _, attr := range attrs {
+		if attr == m.attr {
+			return !m.negate
+		}
+	}
+	return m.negate
+}
+
+func matchAny(alternatives []attrMatch, attrs []string) bool {
+	for _, m := range alternatives {
+		if m.match(attrs) {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidAttr(attr string) bool {
+	if attr == "" {
+		return false
+	}
+	for _, c := range attr {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseIncludeFilter builds a filter from the selectors of an include line,
+// following v2fly semantics: "@attr" requires the attribute and "@-attr"
+// excludes rules carrying it.
+func parseIncludeFilter(rest string) (Filter, error) {
+	var f Filter
+	for _, field := range strings.Fields(rest) {
+		if strings.HasPrefix(field, "#") {
+			break
+		}
+		if !strings.HasPrefix(field, "@") {
+			continue
+		}
+		selector := strings.ToLower(strings.TrimPrefix(field, "@"))
+		negate := strings.HasPrefix(selector, "-")
+		attr := strings.TrimPrefix(selector, "-")
+		if !isValidAttr(attr) {
+			return Filter{}, fmt.Errorf("invalid include selector %q", field)
+		}
+		f.terms = append(f.terms, []attrMatch{{attr: attr, negate: negate}})
+	}
+	return f, nil
+}
+
+// parseAttrs extracts the @attributes from the part of a rule line after its
+// value, stopping at the first comment.
+func parseAttrs(rest string) []string {
+	var attrs []string
+	for _, field := range strings.Fields(rest) {
+		if strings.HasPrefix(field, "#") {
+			break
+		}
+		if !strings.HasPrefix(field, "@") {
+			continue
+		}
+		if attr := strings.ToLower(strings.TrimPrefix(field, "@")); attr != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,150 @@
+package converter_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/xxxbrian/surge-geosite/internal/converter"
+)
+
+func TestFilterMatch(t *testing.T) {
+	testCases := []struct {
+		expr  string
+		attrs []string
+		want  bool
+	}{
+		{"", nil, true},
+		{"", []string{"cn"}, true},
+		{"cn", []string{"cn"}, true},
+		{"cn", []string{"cnx"}, false},
+		{"cn", nil, false},
+		{"@cn", []string{"ads", "cn"}, true},
+		{"cn@ads", []string{"cn"}, false},
+		{"cn@ads", []string{"ads", "cn"}, true},
+		{"!cn", nil, true},
+		{"!cn", []string{"cn"}, false},
+		{"!cn", []string{"cnx"}, true},
+		{"cn|ads", []string{"ads"}, true},
+		{"cn|ads", []string{"games"}, false},
+		{"cn|ads@!games", []string{"cn", "games"}, false},
+	}
+
+	for _, tc := range testCases {
+		f, err := converter.ParseFilter(tc.expr)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q) failed: %v", tc.expr, err)
+		}
+		if got := f.Match(tc.attrs); got != tc.want {
+			t.Errorf("ParseFilter(%q).Match(%v) = %v, want %v", tc.expr, tc.attrs, got, tc.want)
+		}
+	}
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	for _, expr := range []string{"!", "cn|", "c n", "cn@#ads"} {
+		if _, err := converter.ParseFilter(expr); err == nil {
+			t.Errorf("ParseFilter(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestFilterString(t *testing.T) {
+	f, err := converter.ParseFilter("@ads|cn@!games@cn|ads")
+	if err != nil {
+		t.Fatalf("ParseFilter failed: %v", err)
+	}
+	if got, want := f.String(), "!games@ads|cn"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	conv := converter.NewConverter(nil)
+	f, _ := converter.ParseFilter("!cn")
+	items, err := conv.Parse("domain:a.com @cn\nfull:b.com @cnx\nc.com # @cn in comment\nkeyword:d @ads @cn", f)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var got []string
+	for _, item := range items {
+		if item.Kind == converter.ItemRule {
+			got = append(got, item.Rule.Value)
+		}
+	}
+	want := []string{"b.com", "c.com"}
+	if len(got) != len(want) {
+		t.Fatalf("Parse() rules = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Parse() rules = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestIncludeSelectors(t *testing.T) {
+	files := map[string]string{
+		"sub": "domain:a.com @cn\ndomain:b.com @cn @ads\ndomain:c.com @ads\ndomain:d.com",
+	}
+	getter := converter.ListReaderFunc(func(name string) (string, error) {
+		content, ok := files[name]
+		if !ok {
+			return "", fmt.Errorf("file not found: %s", name)
+		}
+		return content, nil
+	})
+
+	testCases := []struct {
+		content string
+		filter  string
+		want    []string
+	}{
+		{"include:sub", "", []string{"a.com", "b.com", "c.com", "d.com"}},
+		{"include:sub @cn", "", []string{"a.com", "b.com"}},
+		{"include:sub @-ads", "", []string{"a.com", "d.com"}},
+		{"include:sub @cn @-ads", "", []string{"a.com"}},
+		{"include:sub @-cn # keep @cn out", "", []string{"c.com", "d.com"}},
+		{"include:sub @-cn", "ads", []string{"c.com"}},
+	}
+
+	for _, tc := range testCases {
+		f, err := converter.ParseFilter(tc.filter)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q) failed: %v", tc.filter, err)
+		}
+		items, err := converter.NewConverter(getter).Parse(tc.content, f)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tc.content, err)
+		}
+		var got []string
+		for _, item := range items {
+			if item.Kind == converter.ItemRule {
+				got = append(got, item.Rule.Value)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("Parse(%q, %q) rules = %v, want %v", tc.content, tc.filter, got, tc.want)
+		}
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	files := map[string]string{
+		"a": "include:b",
+		"b": "domain:b.com\ninclude:c",
+		"c": "include:a",
+	}
+	getter := converter.ListReaderFunc(func(name string) (string, error) {
+		return files[name], nil
+	})
+
+	_, err := converter.NewConverter(getter).Parse(files["a"], converter.Filter{})
+	if err == nil {
+		t.Fatal("Parse succeeded, want include cycle error")
+	}
+	if want := "include cycle detected: b -> c -> a -> b"; err.Error() != want {
+		t.Errorf("Parse error = %q, want %q", err, want)
+	}
+}
@@ -0,0 +1,57 @@
+// Package converter handles the conversion of v2fly domain list format to ruleset formats.
+package converter
+
+import "sync"
+
+// maxIncludeCacheEntries caps the number of memoized includes, since the
+// filter part of each key comes from client requests.
+const maxIncludeCacheEntries = 4096
+
+// IncludeCache memoizes parsed include lists for a single upstream snapshot.
+// Entries are dropped as soon as a different ETag is seen. Cached items are
+// shared between conversions and must not be modified.
+type IncludeCache struct {
+	mu      sync.RWMutex
+	etag    string
+	entries map[string][]Item
+}
+
+// NewIncludeCache creates a new IncludeCache
+func NewIncludeCache() *IncludeCache {
+	return &IncludeCache{
+		entries: make(map[string][]Item),
+	}
+}
+
+// Get returns the parsed items for key if they were cached for etag.
+func (c *IncludeCache) Get(etag, key string) ([]Item, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.etag != etag {
+		return nil, false
+	}
+	items, ok := c.entries[key]
+	return items, ok
+}
+
+// Set stores the parsed items for key, resetting the cache if etag changed.
+func (c *IncludeCache) Set(etag, key string, items []Item) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.etag != etag {
+		c.etag = etag
+		c.entries = make(map[string][]Item)
+	}
+	if len(c.entries) >= maxIncludeCacheEntries {
+		return
+	}
+	c.entries[key] = items
+}
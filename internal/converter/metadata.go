@@ -0,0 +1,181 @@
+// Package converter handles the conversion of v2fly domain list format to ruleset formats.
+package converter
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xxxbrian/surge-geosite/internal/wildcard"
+)
+
+// Metadata describes how a ruleset was generated.
+type Metadata struct {
+	Name   string
+	Filter string
+	// ETag and Commit identify the upstream snapshot the rules come from;
+	// they are empty for rules not read from a snapshot.
+	ETag      string
+	Commit    string
+	Generated time.Time
+	// Counts holds the number of rules of each kind.
+	Counts map[RuleKind]int
+	// Skipped is the number of rules the format does not support.
+	Skipped int
+	// DangerousRegexes is the number of regex rules left out because their
+	// wildcard approximation would match far more than the regex.
+	DangerousRegexes int
+}
+
+// kindNames names rule kinds in metadata, in output order.
+var kindNames = []struct {
+	kind RuleKind
+	name string
+}{
+	{RuleDomainSuffix, "domain-suffix"},
+	{RuleDomain, "domain"},
+	{RuleDomainKeyword, "domain-keyword"},
+	{RuleDomainRegex, "domain-regex"},
+	{RuleIPCIDR, "ip-cidr"},
+}
+
+// NewMetadata counts the rules of items as rendered by r.
+func NewMetadata(items []Item, r Renderer) Metadata {
+	meta := Metadata{Counts: make(map[RuleKind]int)}
+	approximateRegex := r.Support(RuleDomainRegex) == Approximate
+	for _, item := range items {
+		if item.Kind != ItemRule || item.Rule == nil {
+			continue
+		}
+		kind := item.Rule.Kind
+		meta.Counts[kind]++
+		if r.Support(kind) == Unsupported {
+			meta.Skipped++
+			continue
+		}
+		if kind == RuleDomainRegex && approximateRegex && dangerousWildcard(item.Rule.Value) {
+			meta.DangerousRegexes++
+		}
+	}
+	return meta
+}
+
+// dangerousWildcard reports whether a regex is left out instead of being
+// approximated by a wildcard.
+func dangerousWildcard(regex string) bool {
+	return wildcard.IsDangerousRegex(regex) || skipPattern.MatchString(wildcard.RegexToWildcard(regex))
+}
+
+// CountsString formats the rule counts as "domain-suffix=1, domain=2, ...".
+func (m Metadata) CountsString() string {
+	parts := make([]string, 0, len(kindNames))
+	for _, k := range kindNames {
+		parts = append(parts, k.name+"="+strconv.Itoa(m.Counts[k.kind]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Fields returns the metadata as ordered key/value pairs. The upstream
+// fields are left out when unknown.
+func (m Metadata) Fields() [][2]string {
+	filter := m.Filter
+	if filter == "" {
+		filter = "none"
+	}
+	fields := [][2]string{
+		{"name", m.Name},
+		{"filter", filter},
+	}
+	if m.ETag != "" {
+		fields = append(fields, [2]string{"upstream-etag", m.ETag})
+	}
+	if m.Commit != "" {
+		fields = append(fields, [2]string{"upstream-commit", m.Commit})
+	}
+	return append(fields,
+		[2]string{"generated", m.Generated.UTC().Format(time.RFC3339)},
+		[2]string{"rules", m.CountsString()},
+		[2]string{"skipped-rules", strconv.Itoa(m.Skipped)},
+		[2]string{"dangerous-regexes", strconv.Itoa(m.DangerousRegexes)},
+	)
+}
+
+// Headers returns the metadata as X-Geosite-* response headers.
+func (m Metadata) Headers() map[string]string {
+	headers := make(map[string]string)
+	for _, field := range m.Fields() {
+		headers["X-Geosite-"+headerCase(field[0])] = field[1]
+	}
+	return headers
+}
+
+// headerCase converts "upstream-etag" to "Upstream-Etag".
+func headerCase(key string) string {
+	parts := strings.Split(key, "-")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// commentBlock renders the metadata as comment lines starting with prefix.
+func (m Metadata) commentBlock(prefix string) string {
+	var b strings.Builder
+	for _, field := range m.Fields() {
+		b.WriteString(prefix)
+		b.WriteString(" ")
+		b.WriteString(field[0])
+		b.WriteString(": ")
+		b.WriteString(field[1])
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// metadataMap returns the metadata as a JSON object.
+func (m Metadata) metadataMap() map[string]string {
+	fields := m.Fields()
+	values := make(map[string]string, len(fields))
+	for _, field := range fields {
+		values[field[0]] = field[1]
+	}
+	return values
+}
+
+// commentPrefixes holds the comment syntax of formats not using "#".
+var commentPrefixes = map[string]string{
+	"adguard": "!",
+}
+
+// Annotate adds meta to output rendered by r: as a leading comment block in
+// text and YAML formats and as a "metadata" field in JSON formats. Binary
+// output is returned unchanged.
+func Annotate(r Renderer, output string, meta Metadata) (string, error) {
+	switch r.ContentType() {
+	case ContentTypeBinary:
+		return output, nil
+	case ContentTypeJSON:
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(output), &doc); err != nil {
+			return "", err
+		}
+		field, err := json.Marshal(meta.metadataMap())
+		if err != nil {
+			return "", err
+		}
+		doc["metadata"] = field
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	prefix, ok := commentPrefixes[r.Name()]
+	if !ok {
+		prefix = "#"
+	}
+	return strings.TrimRight(meta.commentBlock(prefix)+output, "\n"), nil
+}
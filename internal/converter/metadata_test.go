@@ -0,0 +1,72 @@
+package converter_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xxxbrian/surge-geosite/internal/converter"
+)
+
+func TestMetadata(t *testing.T) {
+	items := converter.IPCIDRItems([]string{"1.2.3.0/24"})
+	items = append(items,
+		converter.Item{Kind: converter.ItemRule, Rule: &converter.Rule{Kind: converter.RuleDomainSuffix, Value: "example.com"}},
+		converter.Item{Kind: converter.ItemRule, Rule: &converter.Rule{Kind: converter.RuleDomainKeyword, Value: "example"}},
+		converter.Item{Kind: converter.ItemRule, Rule: &converter.Rule{Kind: converter.RuleDomainRegex, Value: `^.*$`}},
+	)
+
+	surge, _ := converter.LookupRenderer("surge")
+	meta := converter.NewMetadata(items, surge)
+	if meta.Skipped != 0 || meta.DangerousRegexes != 1 {
+		t.Errorf("surge metadata skipped=%d dangerous=%d, want 0 and 1", meta.Skipped, meta.DangerousRegexes)
+	}
+	if got, want := meta.CountsString(), "domain-suffix=1, domain=0, domain-keyword=1, domain-regex=1, ip-cidr=1"; got != want {
+		t.Errorf("CountsString() = %q, want %q", got, want)
+	}
+
+	domain, _ := converter.LookupRenderer("mihomo-domain")
+	meta = converter.NewMetadata(items, domain)
+	meta.Name = "example"
+	meta.ETag = `"abc"`
+	meta.Commit = "0123456789abcdef0123456789abcdef01234567"
+	meta.Generated = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if meta.Skipped != 3 {
+		t.Errorf("mihomo-domain metadata skipped=%d, want 3", meta.Skipped)
+	}
+	if got := meta.Headers()["X-Geosite-Upstream-Etag"]; got != `"abc"` {
+		t.Errorf("X-Geosite-Upstream-Etag = %q, want %q", got, `"abc"`)
+	}
+	if got := meta.Headers()["X-Geosite-Upstream-Commit"]; got != meta.Commit {
+		t.Errorf("X-Geosite-Upstream-Commit = %q, want %q", got, meta.Commit)
+	}
+
+	output, err := converter.Annotate(domain, "+.example.com", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output, "# name: example\n# filter: none\n# upstream-etag: \"abc\"\n# upstream-commit: 0123456789abcdef0123456789abcdef01234567\n") || !strings.HasSuffix(output, "# dangerous-regexes: 0\n+.example.com") {
+		t.Errorf("Annotate() = %q", output)
+	}
+
+	singBox, _ := converter.LookupRenderer("sing-box")
+	rendered, err := singBox.Render(items, converter.RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err = converter.Annotate(singBox, rendered, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Version  int               `json:"version"`
+		Metadata map[string]string `json:"metadata"`
+	}
+	if err := json.Unmarshal([]byte(output), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != 1 || doc.Metadata["generated"] != "2026-01-02T03:04:05Z" {
+		t.Errorf("sing-box Annotate() = %s", output)
+	}
+}
@@ -0,0 +1,166 @@
+// Package converter handles the conversion of v2fly domain list format to ruleset formats.
+package converter
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/xxxbrian/surge-geosite/internal/mihomo"
+)
+
+func init() {
+	RegisterRenderer(mihomoSyntax.renderer("mihomo-yaml", ContentTypeYAML, plain(RenderMihomoYAML)))
+
+	domain := supportOf(RuleDomainSuffix, RuleDomain)
+	RegisterRenderer(NewRenderer("mihomo-domain", ContentTypeText, domain, plain(RenderMihomoDomain)))
+	RegisterRenderer(NewRenderer("mihomo-domain-yaml", ContentTypeYAML, domain, plain(RenderMihomoDomainYAML)))
+	RegisterRenderer(NewRenderer("mihomo-domain-mrs", ContentTypeBinary, domain, fallible(RenderMihomoDomainBinary)))
+
+	ipcidr := supportOf(RuleIPCIDR)
+	RegisterRenderer(NewRenderer("mihomo-ipcidr", ContentTypeText, ipcidr, plain(RenderMihomoIPCIDR)))
+	RegisterRenderer(NewRenderer("mihomo-ipcidr-yaml", ContentTypeYAML, ipcidr, plain(RenderMihomoIPCIDRYAML)))
+	RegisterRenderer(NewRenderer("mihomo-ipcidr-mrs", ContentTypeBinary, ipcidr, fallible(RenderMihomoIPCIDRBinary)))
+}
+
+// MihomoDomainSet converts parsed rules into a mihomo domain behavior payload.
+// Keyword, regex and IP rules cannot be expressed by the domain behavior and
+// are returned separately.
+func MihomoDomainSet(items []Item) (domains []string, skipped []Rule) {
+	for _, item := range items {
+		if item.Kind != ItemRule || item.Rule == nil {
+			continue
+		}
+		switch item.Rule.Kind {
+		case RuleDomainSuffix:
+			domains = append(domains, "+."+item.Rule.Value)
+		case RuleDomain:
+			domains = append(domains, item.Rule.Value)
+		default:
+			skipped = append(skipped, *item.Rule)
+		}
+	}
+	return domains, skipped
+}
+
+// RenderMihomoDomain renders parsed items into a mihomo domain behavior text
+// rule-provider. Skipped rules are listed in a leading comment block.
+func RenderMihomoDomain(items []Item) string {
+	domains, skipped := MihomoDomainSet(items)
+	var b strings.Builder
+	writeMihomoSkipped(&b, "domain", skipped)
+	b.WriteString(strings.Join(domains, "\n"))
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// RenderMihomoDomainYAML renders parsed items into a mihomo domain behavior
+// YAML rule-provider.
+func RenderMihomoDomainYAML(items []Item) string {
+	domains, skipped := MihomoDomainSet(items)
+	var b strings.Builder
+	writeMihomoSkipped(&b, "domain", skipped)
+	writeMihomoPayload(&b, domains)
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// RenderMihomoDomainBinary renders parsed items into a mihomo domain behavior
+// binary rule-provider (.mrs).
+func RenderMihomoDomainBinary(items []Item) (string, error) {
+	domains, _ := MihomoDomainSet(items)
+	data, err := mihomo.MarshalDomainSet(domains)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// MihomoIPCIDRSet converts parsed rules into a mihomo ipcidr behavior
+// payload, returning the domain rules it cannot express separately.
+func MihomoIPCIDRSet(items []Item) (cidrs []string, skipped []Rule) {
+	for _, item := range items {
+		if item.Kind != ItemRule || item.Rule == nil {
+			continue
+		}
+		if item.Rule.Kind == RuleIPCIDR {
+			cidrs = append(cidrs, item.Rule.Value)
+		} else {
+			skipped = append(skipped, *item.Rule)
+		}
+	}
+	return cidrs, skipped
+}
+
+// RenderMihomoIPCIDR renders parsed items into a mihomo ipcidr behavior text
+// rule-provider, one CIDR per line.
+func RenderMihomoIPCIDR(items []Item) string {
+	cidrs, skipped := MihomoIPCIDRSet(items)
+	var b strings.Builder
+	writeMihomoSkipped(&b, "ipcidr", skipped)
+	b.WriteString(strings.Join(cidrs, "\n"))
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// RenderMihomoIPCIDRYAML renders parsed items into a mihomo ipcidr behavior
+// YAML rule-provider.
+func RenderMihomoIPCIDRYAML(items []Item) string {
+	cidrs, skipped := MihomoIPCIDRSet(items)
+	var b strings.Builder
+	writeMihomoSkipped(&b, "ipcidr", skipped)
+	writeMihomoPayload(&b, cidrs)
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// RenderMihomoIPCIDRBinary renders parsed items into a mihomo ipcidr behavior
+// binary rule-provider (.mrs).
+func RenderMihomoIPCIDRBinary(items []Item) (string, error) {
+	cidrs, _ := MihomoIPCIDRSet(items)
+	data, err := mihomo.MarshalIPCIDRSet(cidrs)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// RenderMihomoYAML renders parsed items into a mihomo classical behavior
+// YAML rule-provider.
+func RenderMihomoYAML(items []Item) string {
+	var payload []string
+	for _, item := range items {
+		if item.Kind != ItemRule || item.Rule == nil {
+			continue
+		}
+		rule := *item.Rule
+		rule.Comment = ""
+		payload = append(payload, mihomoSyntax.render(rule))
+	}
+	var b strings.Builder
+	writeMihomoPayload(&b, payload)
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func writeMihomoSkipped(b *strings.Builder, behavior string, skipped []Rule) {
+	if len(skipped) == 0 {
+		return
+	}
+	b.WriteString("# ")
+	b.WriteString(strconv.Itoa(len(skipped)))
+	b.WriteString(" rules skipped, not supported by the " + behavior + " behavior:\n")
+	for _, rule := range skipped {
+		rule.Comment = ""
+		b.WriteString("# ")
+		b.WriteString(mihomoSyntax.render(rule))
+		b.WriteString("\n")
+	}
+}
+
+func writeMihomoPayload(b *strings.Builder, payload []string) {
+	if len(payload) == 0 {
+		b.WriteString("payload: []\n")
+		return
+	}
+	b.WriteString("payload:\n")
+	for _, value := range payload {
+		b.WriteString("  - ")
+		b.WriteString(strconv.Quote(value))
+		b.WriteString("\n")
+	}
+}
@@ -0,0 +1,138 @@
+// Package converter handles the conversion of v2fly domain list format to ruleset formats.
+package converter
+
+import "strings"
+
+// Optimize removes duplicate rules and rules already covered by a broader
+// rule in the same list: domains and suffixes under another suffix, and
+// domains, suffixes and keywords containing a keyword. Regex rules are only
+// deduplicated. It returns the remaining items and the number of rules removed.
+// Comment items are kept; renderers drop comments left without rules.
+func Optimize(items []Item) ([]Item, int) {
+	idx := newRuleIndex(items)
+	seen := make(map[ruleKey]bool)
+	result := make([]Item, 0, len(items))
+	removed := 0
+
+	for _, item := range items {
+		if item.Kind != ItemRule || item.Rule == nil {
+			result = append(result, item)
+			continue
+		}
+		key := keyOf(*item.Rule)
+		if seen[key] || idx.coversStrictly(*item.Rule) {
+			removed++
+			continue
+		}
+		seen[key] = true
+		result = append(result, item)
+	}
+
+	return result, removed
+}
+
+type ruleKey struct {
+	kind  RuleKind
+	value string
+}
+
+func keyOf(rule Rule) ruleKey {
+	return ruleKey{kind: rule.Kind, value: strings.ToLower(rule.Value)}
+}
+
+// ruleIndex answers whether a rule is matched by a broader rule of a list.
+type ruleIndex struct {
+	domains  map[string]bool
+	suffixes map[string]bool
+	keywords []string
+	regexes  map[string]bool
+}
+
+func newRuleIndex(items []Item) *ruleIndex {
+	idx := &ruleIndex{
+		domains:  make(map[string]bool),
+		suffixes: make(map[string]bool),
+		regexes:  make(map[string]bool),
+	}
+	keywords := make(map[string]bool)
+	for _, item := range items {
+		if item.Kind != ItemRule || item.Rule == nil {
+			continue
+		}
+		value := strings.ToLower(item.Rule.Value)
+		switch item.Rule.Kind {
+		case RuleDomain:
+			idx.domains[value] = true
+		case RuleDomainSuffix:
+			idx.suffixes[value] = true
+		case RuleDomainRegex:
+			idx.regexes[item.Rule.Value] = true
+		case RuleDomainKeyword:
+			if !keywords[value] {
+				keywords[value] = true
+				idx.keywords = append(idx.keywords, value)
+			}
+		}
+	}
+	return idx
+}
+
+// covers reports whether a rule in the index, identical or broader, matches
+// every domain that rule matches.
+func (idx *ruleIndex) covers(rule Rule) bool {
+	value := strings.ToLower(rule.Value)
+	switch rule.Kind {
+	case RuleDomainSuffix:
+		if idx.suffixes[value] {
+			return true
+		}
+	case RuleDomain:
+		if idx.domains[value] {
+			return true
+		}
+	case RuleDomainKeyword:
+		return idx.hasKeywordIn(value, "")
+	case RuleDomainRegex:
+		return idx.regexes[rule.Value]
+	}
+	return idx.coversStrictly(rule)
+}
+
+// coversStrictly reports whether a different, broader rule in the index
+// matches every domain that rule matches.
+func (idx *ruleIndex) coversStrictly(rule Rule) bool {
+	value := strings.ToLower(rule.Value)
+	switch rule.Kind {
+	case RuleDomainSuffix:
+		return idx.hasParentSuffix(value) || idx.hasKeywordIn(value, "")
+	case RuleDomain:
+		return idx.suffixes[value] || idx.hasParentSuffix(value) || idx.hasKeywordIn(value, "")
+	case RuleDomainKeyword:
+		return idx.hasKeywordIn(value, value)
+	}
+	return false
+}
+
+// hasParentSuffix reports whether a proper parent domain of value is a suffix rule.
+func (idx *ruleIndex) hasParentSuffix(value string) bool {
+	for {
+		dot := strings.IndexByte(value, '.')
+		if dot < 0 {
+			return false
+		}
+		value = value[dot+1:]
+		if idx.suffixes[value] {
+			return true
+		}
+	}
+}
+
+// hasKeywordIn reports whether a keyword other than except occurs in value.
+func (idx *ruleIndex) hasKeywordIn(value, except string) bool {
+	for _, keyword := range idx.keywords {
+		if keyword != except && strings.Contains(value, keyword) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package converter_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/xxxbrian/surge-geosite/internal/converter"
+)
+
+func TestOptimize(t *testing.T) {
+	conv := converter.NewConverter(nil)
+	items, err := conv.Parse(`# group
+domain:example.com
+full:a.example.com
+domain:b.example.com
+full:example.com
+domain:example.com
+full:example.org
+keyword:google
+domain:googleapis.com
+full:www.google.cn
+keyword:googlevideo
+regexp:^ad\d+\.example\.net$
+regexp:^ad\d+\.example\.net$`, converter.Filter{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	optimized, removed := converter.Optimize(items)
+
+	var got []string
+	for _, item := range optimized {
+		if item.Kind == converter.ItemRule {
+			got = append(got, item.Rule.Value)
+		}
+	}
+	want := []string{"example.com", "example.org", "google", `^ad\d+\.example\.net$`}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Optimize() rules = %v, want %v", got, want)
+	}
+	if removed != 8 {
+		t.Errorf("Optimize() removed = %d, want 8", removed)
+	}
+}
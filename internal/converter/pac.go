@@ -0,0 +1,218 @@
+// Package converter handles the conversion of v2fly domain list format to ruleset formats.
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/xxxbrian/surge-geosite/internal/wildcard"
+)
+
+// PACRules are the domain rules and CIDRs routed to one PAC target.
+type PACRules struct {
+	Items []Item
+	CIDRs []string
+}
+
+// PACOptions configures the generated PAC script.
+type PACOptions struct {
+	// Proxy is the value returned for proxied hosts, e.g. "PROXY 127.0.0.1:7890".
+	Proxy string
+	// DefaultProxy proxies hosts matched by no rule instead of connecting directly.
+	DefaultProxy bool
+}
+
+// pacTarget is the rule data of one target, encoded into the script as JSON.
+type pacTarget struct {
+	Target    string          `json:"target"`
+	Domains   map[string]bool `json:"domains"`
+	Suffixes  map[string]bool `json:"suffixes"`
+	Keywords  []string        `json:"keywords"`
+	Wildcards []string        `json:"wildcards"`
+	Regexes   []string        `json:"regexes"`
+	Nets      [][2]string     `json:"nets"`
+}
+
+// RenderPAC renders a proxy auto-config script. Domain rules are checked
+// before CIDRs, which need a DNS lookup, and direct rules before proxy rules.
+// Domains and suffixes are looked up in hash sets, regexes are converted to
+// shExpMatch wildcards like for other clients, dangerous ones are evaluated as
+// JavaScript regexes, and CIDRs are matched with isInNet. IPv6 CIDRs are
+// skipped because isInNet only handles IPv4.
+func RenderPAC(direct, proxy PACRules, opts PACOptions) (string, error) {
+	targets := []*pacTarget{
+		newPACTarget("DIRECT", direct),
+		newPACTarget(opts.Proxy, proxy),
+	}
+	hasNets := len(targets[0].Nets) > 0 || len(targets[1].Nets) > 0
+
+	data, err := json.MarshalIndent(targets, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	defaultTarget := "DIRECT"
+	if opts.DefaultProxy {
+		defaultTarget = opts.Proxy
+	}
+	defaultJSON, err := json.Marshal(defaultTarget)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("// Generated by Surge-Geosite\n")
+	fmt.Fprintf(&b, "var rules = %s;\n", data)
+	fmt.Fprintf(&b, "var fallback = %s;\n", defaultJSON)
+	fmt.Fprintf(&b, "var hasNets = %t;\n", hasNets)
+	b.WriteString(pacScript)
+	return b.String(), nil
+}
+
+func newPACTarget(target string, rules PACRules) *pacTarget {
+	t := &pacTarget{
+		Target:    target,
+		Domains:   make(map[string]bool),
+		Suffixes:  make(map[string]bool),
+		Keywords:  []string{},
+		Wildcards: []string{},
+		Regexes:   []string{},
+		Nets:      [][2]string{},
+	}
+	for _, item := range rules.Items {
+		if item.Kind != ItemRule || item.Rule == nil {
+			continue
+		}
+		value := strings.ToLower(item.Rule.Value)
+		switch item.Rule.Kind {
+		case RuleDomain:
+			t.Domains[value] = true
+		case RuleDomainSuffix:
+			t.Suffixes[value] = true
+		case RuleDomainKeyword:
+			t.Keywords = append(t.Keywords, value)
+		case RuleDomainRegex:
+			if !wildcard.IsDangerousRegex(item.Rule.Value) {
+				if pattern := wildcard.RegexToWildcard(item.Rule.Value); !skipPattern.MatchString(pattern) {
+					t.Wildcards = append(t.Wildcards, strings.ToLower(pattern))
+					continue
+				}
+			}
+			t.Regexes = append(t.Regexes, item.Rule.Value)
+		}
+	}
+	t.Keywords = dedupeSorted(t.Keywords)
+	t.Wildcards = dedupeSorted(t.Wildcards)
+	t.Regexes = dedupeSorted(t.Regexes)
+
+	for _, cidr := range rules.CIDRs {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			continue
+		}
+		addr, bits := prefix.Addr(), prefix.Bits()
+		if addr.Is4In6() {
+			addr, bits = addr.Unmap(), bits-96
+		}
+		if !addr.Is4() || bits < 0 {
+			continue
+		}
+		prefix = netip.PrefixFrom(addr, bits).Masked()
+		mask := net.CIDRMask(prefix.Bits(), 32)
+		t.Nets = append(t.Nets, [2]string{prefix.Addr().String(), net.IP(mask).String()})
+	}
+	return t
+}
+
+func dedupeSorted(values []string) []string {
+	sort.Strings(values)
+	result := values[:0]
+	for i, value := range values {
+		if i == 0 || value != values[i-1] {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+const pacScript = `
+for (var i = 0; i < rules.length; i++) {
+  var regexes = [];
+  for (var j = 0; j < rules[i].regexes.length; j++) {
+    try {
+      regexes.push(new RegExp(rules[i].regexes[j]));
+    } catch (e) {
+      // Skip patterns JavaScript cannot compile.
+    }
+  }
+  rules[i].regexes = regexes;
+}
+
+function hasKey(set, key) {
+  return Object.prototype.hasOwnProperty.call(set, key);
+}
+
+function matchHost(rule, host) {
+  if (hasKey(rule.domains, host)) {
+    return true;
+  }
+  for (var h = host; ; ) {
+    if (hasKey(rule.suffixes, h)) {
+      return true;
+    }
+    var dot = h.indexOf(".");
+    if (dot < 0) {
+      break;
+    }
+    h = h.substring(dot + 1);
+  }
+  for (var i = 0; i < rule.keywords.length; i++) {
+    if (host.indexOf(rule.keywords[i]) >= 0) {
+      return true;
+    }
+  }
+  for (var i = 0; i < rule.wildcards.length; i++) {
+    if (shExpMatch(host, rule.wildcards[i])) {
+      return true;
+    }
+  }
+  for (var i = 0; i < rule.regexes.length; i++) {
+    if (rule.regexes[i].test(host)) {
+      return true;
+    }
+  }
+  return false;
+}
+
+function matchIP(rule, ip) {
+  for (var i = 0; i < rule.nets.length; i++) {
+    if (isInNet(ip, rule.nets[i][0], rule.nets[i][1])) {
+      return true;
+    }
+  }
+  return false;
+}
+
+function FindProxyForURL(url, host) {
+  host = host.toLowerCase();
+  for (var i = 0; i < rules.length; i++) {
+    if (matchHost(rules[i], host)) {
+      return rules[i].target;
+    }
+  }
+  if (hasNets) {
+    var ip = /^\d+\.\d+\.\d+\.\d+$/.test(host) ? host : dnsResolve(host);
+    if (ip) {
+      for (var i = 0; i < rules.length; i++) {
+        if (matchIP(rules[i], ip)) {
+          return rules[i].target;
+        }
+      }
+    }
+  }
+  return fallback;
+}
+`
@@ -0,0 +1,150 @@
+package converter_test
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xxxbrian/surge-geosite/internal/converter"
+)
+
+// pacShims implements the PAC helper functions browsers provide, with
+// dnsResolve answering from a fixed table.
+const pacShims = `
+function shExpMatch(str, pattern) {
+  var re = pattern.replace(/[.+^${}()|[\]\\]/g, "\\$&").replace(/\*/g, ".*").replace(/\?/g, ".");
+  return new RegExp("^" + re + "$").test(str);
+}
+function ipToInt(ip) {
+  var parts = ip.split(".");
+  return ((+parts[0] << 24) | (+parts[1] << 16) | (+parts[2] << 8) | +parts[3]) >>> 0;
+}
+function isInNet(ip, net, mask) {
+  return ((ipToInt(ip) & ipToInt(mask)) >>> 0) === ((ipToInt(net) & ipToInt(mask)) >>> 0);
+}
+var resolved = {"intranet.corp": "10.1.1.1", "dns.example": "8.8.8.8"};
+function dnsResolve(host) {
+  return hasKey(resolved, host) ? resolved[host] : null;
+}
+`
+
+func TestRenderPAC(t *testing.T) {
+	conv := converter.NewConverter(nil)
+	direct, err := conv.Parse("google.cn\nfull:example.cn\nregexp:^ad[0-9]+\\.example\\.org$\n", converter.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := conv.Parse("keyword:google\nregexp:^(foo|bar)\\.test$\n", converter.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := converter.RenderPAC(
+		converter.PACRules{Items: direct, CIDRs: []string{"10.0.0.0/8", "::ffff:192.168.0.0/112", "2001:db8::/32"}},
+		converter.PACRules{Items: proxy, CIDRs: []string{"8.8.8.0/24"}},
+		converter.PACOptions{Proxy: "PROXY 127.0.0.1:7890", DefaultProxy: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(script, "var fallback = \"PROXY 127.0.0.1:7890\";\nvar hasNets = true;\n") {
+		t.Error("script does not fall back to the proxy")
+	}
+	if !strings.HasSuffix(script, findProxyForURL) {
+		t.Errorf("script does not end with the expected FindProxyForURL:\n%s", script)
+	}
+	_, data, _ := strings.Cut(script, "var rules = ")
+	data, _, _ = strings.Cut(data, ";\nvar fallback")
+	var rules []struct {
+		Nets [][2]string `json:"nets"`
+	}
+	if err := json.Unmarshal([]byte(data), &rules); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][2]string{{"10.0.0.0", "255.0.0.0"}, {"192.168.0.0", "255.255.0.0"}}; len(rules[0].Nets) != 2 || rules[0].Nets[0] != want[0] || rules[0].Nets[1] != want[1] {
+		t.Errorf("direct nets = %v, want %v without the IPv6 CIDR", rules[0].Nets, want)
+	}
+
+	tests := map[string]string{
+		// Direct rules are checked before the proxy keyword.
+		"google.cn":        "DIRECT",
+		"Mail.Google.CN":   "DIRECT",
+		"google.com":       "PROXY 127.0.0.1:7890",
+		"notgoogle.cn":     "PROXY 127.0.0.1:7890",
+		"example.cn":       "DIRECT",
+		"www.example.cn":   "PROXY 127.0.0.1:7890",
+		"ad12.example.org": "DIRECT",
+		"foo.test":         "PROXY 127.0.0.1:7890",
+		"10.1.2.3":         "DIRECT",
+		"11.1.2.3":         "PROXY 127.0.0.1:7890",
+		"192.168.1.1":      "DIRECT",
+		"8.8.8.8":          "PROXY 127.0.0.1:7890",
+		"intranet.corp":    "DIRECT",
+		"unknown.test":     "PROXY 127.0.0.1:7890",
+	}
+	results := runPAC(t, script, tests)
+	for host, want := range tests {
+		if got := results[host]; got != want {
+			t.Errorf("FindProxyForURL(%s) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+// runPAC evaluates FindProxyForURL of script for every host of tests with
+// Node.js, skipping the test when it is not installed.
+func runPAC(t *testing.T, script string, tests map[string]string) map[string]string {
+	t.Helper()
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node not installed; FindProxyForURL was only checked against the golden source")
+	}
+	hosts, err := json.Marshal(tests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "proxy.pac.js")
+	program := script + pacShims + `
+var results = {};
+var hosts = ` + string(hosts) + `;
+for (var host in hosts) {
+  results[host] = FindProxyForURL("http://" + host + "/", host);
+}
+console.log(JSON.stringify(results));
+`
+	if err := os.WriteFile(file, []byte(program), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(node, file).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	var results map[string]string
+	if err := json.Unmarshal(out, &results); err != nil {
+		t.Fatalf("node output %q: %v", out, err)
+	}
+	return results
+}
+
+// findProxyForURL is the expected entry point of every generated script.
+const findProxyForURL = `function FindProxyForURL(url, host) {
+  host = host.toLowerCase();
+  for (var i = 0; i < rules.length; i++) {
+    if (matchHost(rules[i], host)) {
+      return rules[i].target;
+    }
+  }
+  if (hasNets) {
+    var ip = /^\d+\.\d+\.\d+\.\d+$/.test(host) ? host : dnsResolve(host);
+    if (ip) {
+      for (var i = 0; i < rules.length; i++) {
+        if (matchIP(rules[i], ip)) {
+          return rules[i].target;
+        }
+      }
+    }
+  }
+  return fallback;
+}
+`
@@ -7,7 +7,7 @@ import (
 )
 
 // Parse converts upstream content into parsed items with filter support.
-func (c *Converter) Parse(upstreamContent string, filter string) ([]Item, error) {
+func (c *Converter) Parse(upstreamContent string, filter Filter) ([]Item, error) {
 	lines := strings.Split(upstreamContent, "\n")
 	var items []Item
 
@@ -58,7 +58,7 @@ func (c *Converter) Parse(upstreamContent string, filter string) ([]Item, error)
 	return items, nil
 }
 
-func parseRuleLine(line, fromPrefix string, kind RuleKind, filter string) (Rule, bool) {
+func parseRuleLine(line, fromPrefix string, kind RuleKind, filter Filter) (Rule, bool) {
 	parts := strings.SplitN(line, " ", 2)
 	value := parts[0]
 	if fromPrefix != "" {
@@ -69,50 +69,34 @@ func parseRuleLine(line, fromPrefix string, kind RuleKind, filter string) (Rule,
 		rest = parts[1]
 	}
 
-	if !matchesFilter(rest, filter) {
+	attrs := parseAttrs(rest)
+	if !filter.Match(attrs) {
 		return Rule{}, false
 	}
 
 	return Rule{
 		Kind:    kind,
 		Value:   value,
+		Attrs:   attrs,
 		Comment: rest,
 	}, true
 }
 
-func matchesFilter(rest string, filter string) bool {
-	if filter == "" {
-		return true
-	}
-
-	trimmedRest := strings.TrimSpace(rest)
-	filterString := "@" + filter
-
-	if !strings.HasPrefix(trimmedRest, "@") {
-		return false
-	}
-
-	commentIndex := strings.Index(trimmedRest, "#")
-	filterIndex := strings.Index(trimmedRest, filterString)
-
-	if filterIndex == -1 || (commentIndex != -1 && commentIndex < filterIndex) {
-		return false
-	}
-
-	return true
-}
-
-func (c *Converter) parseInclude(line string, filter string) ([]Item, error) {
+// parseInclude expands an include line. Attribute selectors on the line
+// ("include:foo @cn @-ads") narrow the included rules on top of the
+// request filter.
+func (c *Converter) parseInclude(line string, filter Filter) ([]Item, error) {
 	parts := strings.SplitN(line, " ", 2)
 	subContentName := strings.TrimPrefix(parts[0], "include:")
-
-	subContent, err := c.fileGetter(c.zipReader, subContentName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch sub-upstream content: %w", err)
+	if len(parts) > 1 {
+		includeFilter, err := parseIncludeFilter(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid include %q: %w", line, err)
+		}
+		filter = filter.And(includeFilter)
 	}
 
-	subConverter := NewConverter(c.zipReader, c.fileGetter)
-	subItems, err := subConverter.Parse(subContent, filter)
+	subItems, err := c.expandInclude(subContentName, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -130,6 +114,48 @@ func (c *Converter) parseInclude(line string, filter string) ([]Item, error) {
 	return items, nil
 }
 
+// expandInclude parses an included list, guarding against cycles and runaway
+// nesting and reusing results already parsed with the same filter.
+func (c *Converter) expandInclude(name string, filter Filter) ([]Item, error) {
+	for i, parent := range c.stack {
+		if parent == name {
+			chain := append(append([]string{}, c.stack[i:]...), name)
+			return nil, fmt.Errorf("include cycle detected: %s", strings.Join(chain, " -> "))
+		}
+	}
+	if len(c.stack) >= maxIncludeDepth {
+		return nil, fmt.Errorf("include depth exceeds %d at %s", maxIncludeDepth, name)
+	}
+
+	key := name
+	if !filter.IsEmpty() {
+		key += "@" + filter.String()
+	}
+	if items, ok := c.memo[key]; ok {
+		return items, nil
+	}
+	if items, ok := c.includeCache.Get(c.etag, key); ok {
+		c.memo[key] = items
+		return items, nil
+	}
+
+	subContent, err := c.lists.ReadList(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sub-upstream content: %w", err)
+	}
+
+	c.stack = append(c.stack, name)
+	items, err := c.Parse(subContent, filter)
+	c.stack = c.stack[:len(c.stack)-1]
+	if err != nil {
+		return nil, err
+	}
+
+	c.memo[key] = items
+	c.includeCache.Set(c.etag, key, items)
+	return items, nil
+}
+
 func hasRules(items []Item) bool {
 	for _, item := range items {
 		if item.Kind == ItemRule {
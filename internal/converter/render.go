@@ -12,55 +12,70 @@ import (
 // skipPattern matches patterns that result in only wildcards
 var skipPattern = regexp.MustCompile(`^[\?\*]+$`)
 
+func init() {
+	RegisterRenderer(surgeSyntax.renderer("surge", ContentTypeText, plain(RenderSurge)))
+	RegisterRenderer(mihomoSyntax.renderer("mihomo", ContentTypeText, plain(RenderMihomo)))
+	RegisterRenderer(loonSyntax.renderer("loon", ContentTypeText, plain(RenderLoon)))
+	RegisterRenderer(shadowrocketSyntax.renderer("shadowrocket", ContentTypeText, plain(RenderShadowrocket)))
+	RegisterRenderer(stashSyntax.renderer("stash", ContentTypeText, plain(RenderStash)))
+	RegisterRenderer(quantumultXSyntax.renderer("quantumultx", ContentTypeText, func(items []Item, opts RenderOptions) (string, error) {
+		return RenderQuantumultX(items, opts.Policy), nil
+	}))
+	RegisterRenderer(NewRenderer("egern", ContentTypeYAML,
+		supportOf(RuleDomainSuffix, RuleDomain, RuleDomainKeyword, RuleDomainRegex, RuleIPCIDR), plain(RenderEgern)))
+	RegisterRenderer(NewRenderer("surge-domainset", ContentTypeText,
+		supportOf(RuleDomainSuffix, RuleDomain), func(items []Item, opts RenderOptions) (string, error) {
+			return RenderSurgeDomainSet(items, opts.ResidualURL), nil
+		}))
+	RegisterRenderer(NewRenderer("list", ContentTypeText, supportOf(RuleIPCIDR), plain(RenderIPList)))
+}
+
 // RenderSurge renders parsed items into Surge ruleset format.
 func RenderSurge(items []Item) string {
-	var result []string
-	var pendingIncludeComments []string
-	var pendingComment string
-
-	for _, item := range items {
-		if item.Kind == ItemComment {
-			trimmed := strings.TrimSpace(item.Comment)
-			if strings.HasPrefix(trimmed, "# include:") {
-				pendingIncludeComments = append(pendingIncludeComments, item.Comment)
-			} else {
-				pendingComment = item.Comment
-			}
-			continue
-		}
+	return renderRuleLines(items, surgeSyntax.render, false)
+}
 
-		if item.Kind != ItemRule || item.Rule == nil {
-			continue
-		}
+// RenderMihomo renders parsed items into Mihomo classical ruleset format.
+// Unlike Surge, Mihomo supports DOMAIN-REGEX natively.
+func RenderMihomo(items []Item) string {
+	return renderRuleLines(items, mihomoSyntax.render, false)
+}
 
-		line := renderSurgeRule(*item.Rule)
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "#") {
-			pendingComment = line
-			continue
-		}
+// RenderLoon renders parsed items into Loon rule list format.
+func RenderLoon(items []Item) string {
+	return renderRuleLines(items, loonSyntax.render, true)
+}
 
-		if len(pendingIncludeComments) > 0 {
-			result = append(result, pendingIncludeComments...)
-			pendingIncludeComments = pendingIncludeComments[:0]
-		}
-		if pendingComment != "" {
-			result = append(result, pendingComment)
-			pendingComment = ""
-		}
+// RenderShadowrocket renders parsed items into Shadowrocket rule list
+// format.
+func RenderShadowrocket(items []Item) string {
+	return renderRuleLines(items, shadowrocketSyntax.render, true)
+}
 
-		result = append(result, line)
-	}
+// RenderStash renders parsed items into Stash classical rule-set format.
+// Unlike Loon and Shadowrocket, Stash supports DOMAIN-REGEX natively.
+func RenderStash(items []Item) string {
+	return renderRuleLines(items, stashSyntax.render, true)
+}
 
-	return strings.Join(result, "\n")
+// RenderQuantumultX renders parsed items into a Quantumult X filter list,
+// assigning every rule to policy.
+func RenderQuantumultX(items []Item, policy string) string {
+	syntax := quantumultXSyntax
+	syntax.policy = policy
+	return renderRuleLines(items, syntax.render, true)
 }
 
-// RenderMihomo renders parsed items into Mihomo classical ruleset format.
-// Unlike Surge, Mihomo supports DOMAIN-REGEX natively.
-func RenderMihomo(items []Item) string {
+// renderRuleLines renders items one rule per line. Upstream comments are
+// only kept when followed by a rule. Rules a client cannot express are
+// rendered as comments: with keepRuleComments they are all kept after the
+// upstream comment, otherwise, as Surge and Mihomo output always did, such a
+// comment replaces the upstream comment and is dropped at the end.
+func renderRuleLines(items []Item, renderRule func(Rule) string, keepRuleComments bool) string {
 	var result []string
 	var pendingIncludeComments []string
 	var pendingComment string
+	var pendingRuleComments []string
 
 	for _, item := range items {
 		if item.Kind == ItemComment {
@@ -77,10 +92,14 @@ func RenderMihomo(items []Item) string {
 			continue
 		}
 
-		line := renderMihomoRule(*item.Rule)
+		line := renderRule(*item.Rule)
 		trimmed := strings.TrimSpace(line)
 		if strings.HasPrefix(trimmed, "#") {
-			pendingComment = line
+			if keepRuleComments {
+				pendingRuleComments = append(pendingRuleComments, line)
+			} else {
+				pendingComment = line
+			}
 			continue
 		}
 
@@ -92,35 +111,159 @@ func RenderMihomo(items []Item) string {
 			result = append(result, pendingComment)
 			pendingComment = ""
 		}
+		if len(pendingRuleComments) > 0 {
+			result = append(result, pendingRuleComments...)
+			pendingRuleComments = pendingRuleComments[:0]
+		}
 
 		result = append(result, line)
 	}
 
+	if len(pendingRuleComments) > 0 {
+		result = append(result, pendingIncludeComments...)
+		result = append(result, pendingRuleComments...)
+	}
+
 	return strings.Join(result, "\n")
 }
 
-func renderMihomoRule(rule Rule) string {
+// ruleSyntax describes how a rule-list client spells each rule kind. An
+// empty name marks the kind as unsupported.
+type ruleSyntax struct {
+	suffix   string
+	domain   string
+	keyword  string
+	wildcard string
+	regex    string
+	ipCIDR   string
+	ipCIDR6  string
+	// policy is appended to every rule when set, as Quantumult X requires.
+	policy string
+	// noInlineComments drops trailing comments the client cannot parse.
+	noInlineComments bool
+}
+
+var (
+	surgeSyntax = ruleSyntax{
+		suffix: "DOMAIN-SUFFIX", domain: "DOMAIN", keyword: "DOMAIN-KEYWORD",
+		wildcard: "DOMAIN-WILDCARD", ipCIDR: "IP-CIDR", ipCIDR6: "IP-CIDR6",
+	}
+	// loonSyntax has no domain regex rule; URL-REGEX matches whole URLs.
+	loonSyntax = ruleSyntax{
+		suffix: "DOMAIN-SUFFIX", domain: "DOMAIN", keyword: "DOMAIN-KEYWORD",
+		wildcard: "DOMAIN-WILDCARD", ipCIDR: "IP-CIDR", ipCIDR6: "IP-CIDR6",
+	}
+	// shadowrocketSyntax has no domain regex rule either.
+	shadowrocketSyntax = ruleSyntax{
+		suffix: "DOMAIN-SUFFIX", domain: "DOMAIN", keyword: "DOMAIN-KEYWORD",
+		wildcard: "DOMAIN-WILDCARD", ipCIDR: "IP-CIDR", ipCIDR6: "IP-CIDR6",
+	}
+	// stashSyntax matches regexes natively, so they are not converted to
+	// wildcards.
+	stashSyntax = ruleSyntax{
+		suffix: "DOMAIN-SUFFIX", domain: "DOMAIN", keyword: "DOMAIN-KEYWORD",
+		wildcard: "DOMAIN-WILDCARD", regex: "DOMAIN-REGEX", ipCIDR: "IP-CIDR", ipCIDR6: "IP-CIDR6",
+	}
+	mihomoSyntax = ruleSyntax{
+		suffix: "DOMAIN-SUFFIX", domain: "DOMAIN", keyword: "DOMAIN-KEYWORD",
+		regex: "DOMAIN-REGEX", ipCIDR: "IP-CIDR", ipCIDR6: "IP-CIDR6",
+	}
+	quantumultXSyntax = ruleSyntax{
+		suffix: "host-suffix", domain: "host", keyword: "host-keyword",
+		wildcard: "host-wildcard", ipCIDR: "ip-cidr", ipCIDR6: "ip6-cidr",
+		noInlineComments: true,
+	}
+)
+
+func (s ruleSyntax) render(rule Rule) string {
+	if s.noInlineComments {
+		rule.Comment = ""
+	}
 	switch rule.Kind {
 	case RuleDomainSuffix:
-		return appendComment("DOMAIN-SUFFIX,"+rule.Value, rule.Comment)
+		return s.line(s.suffix, "DOMAIN-SUFFIX", rule.Value, rule.Comment)
 	case RuleDomain:
-		return appendComment("DOMAIN,"+rule.Value, rule.Comment)
+		return s.line(s.domain, "DOMAIN", rule.Value, rule.Comment)
 	case RuleDomainKeyword:
-		return appendComment("DOMAIN-KEYWORD,"+rule.Value, rule.Comment)
+		return s.line(s.keyword, "DOMAIN-KEYWORD", rule.Value, rule.Comment)
 	case RuleDomainRegex:
-		// Mihomo supports DOMAIN-REGEX natively, output original regex
-		return appendComment("DOMAIN-REGEX,"+rule.Value, rule.Comment)
+		if s.regex != "" || s.wildcard == "" {
+			return s.line(s.regex, "DOMAIN-REGEX", rule.Value, rule.Comment)
+		}
+		// Check if the regex would result in a dangerously broad wildcard
+		if wildcard.IsDangerousRegex(rule.Value) {
+			// Skip dangerous regex, output as comment with original value
+			return appendComment("# DANGEROUS-REGEX,"+rule.Value, rule.Comment)
+		}
+		wildcardPattern := wildcard.RegexToWildcard(rule.Value)
+		if skipPattern.MatchString(wildcardPattern) {
+			return appendComment("# SKIPPED-"+s.wildcard+","+wildcardPattern, rule.Comment)
+		}
+		return s.line(s.wildcard, "DOMAIN-WILDCARD", wildcardPattern, rule.Comment)
+	case RuleIPCIDR:
+		if rule.isIPv6() {
+			return s.line(s.ipCIDR6, "IP-CIDR6", rule.Value, rule.Comment)
+		}
+		return s.line(s.ipCIDR, "IP-CIDR", rule.Value, rule.Comment)
 	default:
 		return appendComment(rule.Value, rule.Comment)
 	}
 }
 
+// support reports how the client handles rules of kind.
+func (s ruleSyntax) support(kind RuleKind) Support {
+	var name string
+	switch kind {
+	case RuleDomainSuffix:
+		name = s.suffix
+	case RuleDomain:
+		name = s.domain
+	case RuleDomainKeyword:
+		name = s.keyword
+	case RuleDomainRegex:
+		if s.regex == "" && s.wildcard != "" {
+			return Approximate
+		}
+		name = s.regex
+	case RuleIPCIDR:
+		name = s.ipCIDR
+	}
+	if name == "" {
+		return Unsupported
+	}
+	return Native
+}
+
+// renderer returns a Renderer for the client using render.
+func (s ruleSyntax) renderer(name, contentType string, render RenderFunc) Renderer {
+	support := make(map[RuleKind]Support)
+	for _, kind := range []RuleKind{RuleDomainSuffix, RuleDomain, RuleDomainKeyword, RuleDomainRegex, RuleIPCIDR} {
+		support[kind] = s.support(kind)
+	}
+	return NewRenderer(name, contentType, support, render)
+}
+
+// line renders a single rule, or an UNSUPPORTED comment naming the generic
+// rule type when the client has no equivalent.
+func (s ruleSyntax) line(name, generic, value, comment string) string {
+	if name == "" {
+		return appendComment("# UNSUPPORTED-"+generic+","+value, comment)
+	}
+	line := name + "," + value
+	if s.policy != "" {
+		line += "," + s.policy
+	}
+	return appendComment(line, comment)
+}
+
 // RenderEgern renders parsed items into Egern ruleset YAML.
 func RenderEgern(items []Item) string {
 	var domainSet []string
 	var domainSuffixSet []string
 	var domainKeywordSet []string
 	var domainRegexSet []string
+	var ipCIDRSet []string
+	var ipCIDR6Set []string
 
 	for _, item := range items {
 		if item.Kind != ItemRule || item.Rule == nil {
@@ -135,6 +278,12 @@ func RenderEgern(items []Item) string {
 			domainKeywordSet = append(domainKeywordSet, item.Rule.Value)
 		case RuleDomainRegex:
 			domainRegexSet = append(domainRegexSet, item.Rule.Value)
+		case RuleIPCIDR:
+			if item.Rule.isIPv6() {
+				ipCIDR6Set = append(ipCIDR6Set, item.Rule.Value)
+			} else {
+				ipCIDRSet = append(ipCIDRSet, item.Rule.Value)
+			}
 		}
 	}
 
@@ -156,35 +305,12 @@ func RenderEgern(items []Item) string {
 	appendSet("domain_suffix_set", domainSuffixSet)
 	appendSet("domain_keyword_set", domainKeywordSet)
 	appendSet("domain_regex_set", domainRegexSet)
+	appendSet("ip_cidr_set", ipCIDRSet)
+	appendSet("ip_cidr6_set", ipCIDR6Set)
 
 	return strings.TrimRight(b.String(), "\n")
 }
 
-func renderSurgeRule(rule Rule) string {
-	switch rule.Kind {
-	case RuleDomainSuffix:
-		return appendComment("DOMAIN-SUFFIX,"+rule.Value, rule.Comment)
-	case RuleDomain:
-		return appendComment("DOMAIN,"+rule.Value, rule.Comment)
-	case RuleDomainKeyword:
-		return appendComment("DOMAIN-KEYWORD,"+rule.Value, rule.Comment)
-	case RuleDomainRegex:
-		// Check if the regex would result in a dangerously broad wildcard
-		if wildcard.IsDangerousRegex(rule.Value) {
-			// Skip dangerous regex, output as comment with original value
-			return appendComment("# DANGEROUS-REGEX,"+rule.Value, rule.Comment)
-		}
-		wildcardPattern := wildcard.RegexToWildcard(rule.Value)
-		prefix := "DOMAIN-WILDCARD,"
-		if skipPattern.MatchString(wildcardPattern) {
-			prefix = "# SKIPPED-DOMAIN-WILDCARD,"
-		}
-		return appendComment(prefix+wildcardPattern, rule.Comment)
-	default:
-		return appendComment(rule.Value, rule.Comment)
-	}
-}
-
 func appendComment(line string, comment string) string {
 	if comment == "" {
 		return line
@@ -194,3 +320,59 @@ func appendComment(line string, comment string) string {
 	}
 	return line + " # " + comment
 }
+
+// RenderSurgeDomainSet renders parsed items into a Surge DOMAIN-SET list.
+// Keyword, regex and IP rules cannot be expressed in a DOMAIN-SET; when
+// present, a leading comment points to residualURL, a RULE-SET serving just those.
+func RenderSurgeDomainSet(items []Item, residualURL string) string {
+	var lines []string
+	skipped := 0
+	for _, item := range items {
+		if item.Kind != ItemRule || item.Rule == nil {
+			continue
+		}
+		switch item.Rule.Kind {
+		case RuleDomainSuffix:
+			lines = append(lines, "."+item.Rule.Value)
+		case RuleDomain:
+			lines = append(lines, item.Rule.Value)
+		default:
+			skipped++
+		}
+	}
+
+	if skipped > 0 {
+		header := []string{"# " + strconv.Itoa(skipped) + " keyword/regex/IP rules are not supported by DOMAIN-SET"}
+		if residualURL != "" {
+			header = append(header, "# Add them with RULE-SET,"+residualURL)
+		}
+		lines = append(header, lines...)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// DomainSetResidual returns the items a DOMAIN-SET cannot express, for use
+// as a companion RULE-SET.
+func DomainSetResidual(items []Item) []Item {
+	result := make([]Item, 0, len(items))
+	for _, item := range items {
+		if item.Kind == ItemRule && item.Rule != nil &&
+			(item.Rule.Kind == RuleDomainSuffix || item.Rule.Kind == RuleDomain) {
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// RenderIPList renders the IP CIDR rules of items one prefix per line.
+func RenderIPList(items []Item) string {
+	var lines []string
+	for _, item := range items {
+		if item.Kind == ItemRule && item.Rule != nil && item.Rule.Kind == RuleIPCIDR {
+			lines = append(lines, item.Rule.Value)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,84 @@
+package converter_test
+
+import (
+	"testing"
+
+	"github.com/xxxbrian/surge-geosite/internal/converter"
+)
+
+// renderSample is a list holding every rule kind, including a regex that
+// cannot be expressed as a wildcard.
+const renderSample = "# Example\ndomain:example.com\nfull:www.example.com # www\nkeyword:exam\nregexp:^(.+\\.)?example\\.org$\nregexp:.*\n"
+
+func TestRenderRuleLists(t *testing.T) {
+	items, err := converter.NewConverter(nil).Parse(renderSample, converter.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items = append(items, converter.IPCIDRItems([]string{"1.2.3.0/24", "2001:db8::/32"})...)
+
+	surgeStyle := "# Example\nDOMAIN-SUFFIX,example.com\nDOMAIN,www.example.com # www\nDOMAIN-KEYWORD,exam\nDOMAIN-WILDCARD,*example.org\n# DANGEROUS-REGEX,.*\nIP-CIDR,1.2.3.0/24\nIP-CIDR6,2001:db8::/32"
+	tests := map[string]string{
+		"loon":         surgeStyle,
+		"shadowrocket": surgeStyle,
+		"stash":        "# Example\nDOMAIN-SUFFIX,example.com\nDOMAIN,www.example.com # www\nDOMAIN-KEYWORD,exam\nDOMAIN-REGEX,^(.+\\.)?example\\.org$\nDOMAIN-REGEX,.*\nIP-CIDR,1.2.3.0/24\nIP-CIDR6,2001:db8::/32",
+		"quantumultx":  "# Example\nhost-suffix,example.com,Proxy\nhost,www.example.com,Proxy\nhost-keyword,exam,Proxy\nhost-wildcard,*example.org,Proxy\n# DANGEROUS-REGEX,.*\nip-cidr,1.2.3.0/24,Proxy\nip6-cidr,2001:db8::/32,Proxy",
+		"surge":        surgeStyle,
+	}
+	for name, want := range tests {
+		r, ok := converter.LookupRenderer(name)
+		if !ok {
+			t.Errorf("%s renderer not registered", name)
+			continue
+		}
+		got, err := r.Render(items, converter.RenderOptions{Policy: "Proxy"})
+		if err != nil {
+			t.Errorf("%s Render() error: %v", name, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s Render() =\n%s\nwant\n%s", name, got, want)
+		}
+	}
+}
+
+func TestRenderKeepsUnsupportedRules(t *testing.T) {
+	items, err := converter.NewConverter(nil).Parse("domain:example.com\nregexp:.*\n", converter.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A trailing unsupported rule is dropped by Surge but kept by the
+	// newer clients so users can see what is missing.
+	if got := converter.RenderSurge(items); got != "DOMAIN-SUFFIX,example.com" {
+		t.Errorf("RenderSurge() = %q", got)
+	}
+	if got, want := converter.RenderLoon(items), "DOMAIN-SUFFIX,example.com\n# DANGEROUS-REGEX,.*"; got != want {
+		t.Errorf("RenderLoon() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderSurgeDomainSet(t *testing.T) {
+	items, err := converter.NewConverter(nil).Parse(renderSample, converter.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "# 3 keyword/regex/IP rules are not supported by DOMAIN-SET\n# Add them with RULE-SET,https://example.net/geosite/surge/example?residual=1\n.example.com\nwww.example.com"
+	if got := converter.RenderSurgeDomainSet(items, "https://example.net/geosite/surge/example?residual=1"); got != want {
+		t.Errorf("RenderSurgeDomainSet() =\n%s\nwant\n%s", got, want)
+	}
+	want = "# 3 keyword/regex/IP rules are not supported by DOMAIN-SET\n.example.com\nwww.example.com"
+	if got := converter.RenderSurgeDomainSet(items, ""); got != want {
+		t.Errorf("RenderSurgeDomainSet() without residual URL =\n%s\nwant\n%s", got, want)
+	}
+
+	residual := converter.DomainSetResidual(items)
+	// Surge output keeps a commented rule only ahead of a following rule.
+	want = "# Example\nDOMAIN-KEYWORD,exam\nDOMAIN-WILDCARD,*example.org"
+	if got := converter.RenderSurge(residual); got != want {
+		t.Errorf("DomainSetResidual() renders as\n%s\nwant\n%s", got, want)
+	}
+	if got := converter.RenderSurgeDomainSet(residual, ""); got != "# 3 keyword/regex/IP rules are not supported by DOMAIN-SET" {
+		t.Errorf("residual items hold domains: %q", got)
+	}
+}
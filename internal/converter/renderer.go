@@ -0,0 +1,146 @@
+// Package converter handles the conversion of v2fly domain list format to ruleset formats.
+package converter
+
+import (
+	"sort"
+	"sync"
+)
+
+// Support describes how a renderer handles a rule kind.
+type Support int
+
+const (
+	// Unsupported rules are left out of the output, listed as comments where
+	// the format allows.
+	Unsupported Support = iota
+	// Approximate rules are converted to a broader rule the format supports,
+	// such as a regex to a wildcard.
+	Approximate
+	// Native rules are expressed exactly.
+	Native
+)
+
+// Content types of rendered output.
+const (
+	ContentTypeText   = "text/plain; charset=utf-8"
+	ContentTypeYAML   = "text/yaml; charset=utf-8"
+	ContentTypeJSON   = "application/json; charset=utf-8"
+	ContentTypeBinary = "application/octet-stream"
+)
+
+// RenderOptions carries per-request rendering parameters.
+type RenderOptions struct {
+	// Policy is the target policy of formats that name one in every rule.
+	Policy string
+	// DNS configures the DNS-layer formats.
+	DNS DNSOptions
+	// ResidualURL is the companion RULE-SET of a Surge DOMAIN-SET.
+	ResidualURL string
+}
+
+// Renderer renders domain and IP rules into one output format.
+type Renderer interface {
+	// Name identifies the format in URLs and the format query parameter.
+	Name() string
+	// ContentType is the Content-Type of the rendered output.
+	ContentType() string
+	// Support reports how rules of kind are rendered.
+	Support(kind RuleKind) Support
+	// Render renders items, degrading rules as reported by Support.
+	Render(items []Item, opts RenderOptions) (string, error)
+}
+
+// RenderFunc renders items into a format.
+type RenderFunc func(items []Item, opts RenderOptions) (string, error)
+
+type renderer struct {
+	name        string
+	contentType string
+	support     map[RuleKind]Support
+	render      RenderFunc
+}
+
+// NewRenderer returns a Renderer rendering with render and handling each rule
+// kind as given by support; kinds missing from support are Unsupported.
+func NewRenderer(name, contentType string, support map[RuleKind]Support, render RenderFunc) Renderer {
+	return &renderer{name: name, contentType: contentType, support: support, render: render}
+}
+
+func (r *renderer) Name() string                  { return r.name }
+func (r *renderer) ContentType() string           { return r.contentType }
+func (r *renderer) Support(kind RuleKind) Support { return r.support[kind] }
+
+func (r *renderer) Render(items []Item, opts RenderOptions) (string, error) {
+	return r.render(items, opts)
+}
+
+var (
+	renderersMu sync.RWMutex
+	renderers   = make(map[string]Renderer)
+)
+
+// RegisterRenderer makes a renderer available by name, replacing any
+// renderer registered under the same name.
+func RegisterRenderer(r Renderer) {
+	renderersMu.Lock()
+	defer renderersMu.Unlock()
+	renderers[r.Name()] = r
+}
+
+// LookupRenderer returns the renderer registered under name.
+func LookupRenderer(name string) (Renderer, bool) {
+	renderersMu.RLock()
+	defer renderersMu.RUnlock()
+	r, ok := renderers[name]
+	return r, ok
+}
+
+// Renderers returns every registered renderer, sorted by name.
+func Renderers() []Renderer {
+	renderersMu.RLock()
+	defer renderersMu.RUnlock()
+	list := make([]Renderer, 0, len(renderers))
+	for _, r := range renderers {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list
+}
+
+// SupportsDomains reports whether r renders at least one domain rule kind.
+func SupportsDomains(r Renderer) bool {
+	for _, kind := range []RuleKind{RuleDomainSuffix, RuleDomain, RuleDomainKeyword, RuleDomainRegex} {
+		if r.Support(kind) != Unsupported {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsIPs reports whether r renders IP CIDR rules.
+func SupportsIPs(r Renderer) bool {
+	return r.Support(RuleIPCIDR) != Unsupported
+}
+
+// plain adapts a renderer without options or errors to a RenderFunc.
+func plain(render func(items []Item) string) RenderFunc {
+	return func(items []Item, _ RenderOptions) (string, error) {
+		return render(items), nil
+	}
+}
+
+// fallible adapts a renderer without options to a RenderFunc.
+func fallible(render func(items []Item) (string, error)) RenderFunc {
+	return func(items []Item, _ RenderOptions) (string, error) {
+		return render(items)
+	}
+}
+
+// supportOf returns a support map with the given kinds rendered natively.
+func supportOf(kinds ...RuleKind) map[RuleKind]Support {
+	support := make(map[RuleKind]Support, len(kinds))
+	for _, kind := range kinds {
+		support[kind] = Native
+	}
+	return support
+}
@@ -0,0 +1,73 @@
+// Package converter handles the conversion of v2fly domain list format to ruleset formats.
+package converter
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ParseRuleList parses a Surge rule list such as the /misc upstream lists.
+// Domain and IP rules are converted to items; rules of other types are
+// returned separately. DOMAIN-WILDCARD patterns become anchored regexes.
+func ParseRuleList(content string) (items []Item, skipped []string) {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			items = append(items, Item{Kind: ItemComment, Comment: "# " + strings.TrimLeft(line, "#/ ")})
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		if len(fields) < 2 {
+			skipped = append(skipped, line)
+			continue
+		}
+		value := strings.TrimSpace(fields[1])
+		var rule Rule
+		switch strings.ToUpper(strings.TrimSpace(fields[0])) {
+		case "DOMAIN":
+			rule = Rule{Kind: RuleDomain, Value: value}
+		case "DOMAIN-SUFFIX":
+			rule = Rule{Kind: RuleDomainSuffix, Value: value}
+		case "DOMAIN-KEYWORD":
+			rule = Rule{Kind: RuleDomainKeyword, Value: value}
+		case "DOMAIN-WILDCARD":
+			rule = Rule{Kind: RuleDomainRegex, Value: wildcardToRegex(value)}
+		case "DOMAIN-REGEX":
+			rule = Rule{Kind: RuleDomainRegex, Value: value}
+		case "IP-CIDR", "IP-CIDR6":
+			rule = Rule{Kind: RuleIPCIDR, Value: value}
+		default:
+			skipped = append(skipped, line)
+			continue
+		}
+		if value == "" {
+			skipped = append(skipped, line)
+			continue
+		}
+		items = append(items, Item{Kind: ItemRule, Rule: &rule})
+	}
+	return items, skipped
+}
+
+// wildcardToRegex converts a Surge wildcard ("*" any run, "?" one character)
+// into an anchored regex.
+func wildcardToRegex(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
@@ -0,0 +1,31 @@
+package converter_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/xxxbrian/surge-geosite/internal/converter"
+)
+
+func TestParseRuleList(t *testing.T) {
+	items, skipped := converter.ParseRuleList("# AI\nDOMAIN-SUFFIX,openai.com\nDOMAIN,chat.openai.com,extended-matching\nDOMAIN-WILDCARD,*.ai?.net\nIP-CIDR,1.2.3.0/24,no-resolve\nPROCESS-NAME,foo")
+	if got, want := fmt.Sprint(ruleValues(items)), `[openai.com chat.openai.com ^.*\.ai.\.net$ 1.2.3.0/24]`; got != want {
+		t.Errorf("ParseRuleList() = %s, want %s", got, want)
+	}
+	if len(skipped) != 1 || skipped[0] != "PROCESS-NAME,foo" {
+		t.Errorf("ParseRuleList() skipped = %v, want [PROCESS-NAME,foo]", skipped)
+	}
+
+	r, ok := converter.LookupRenderer("surge")
+	if !ok {
+		t.Fatal("surge renderer not registered")
+	}
+	output, err := r.Render(items, converter.RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# AI\nDOMAIN-SUFFIX,openai.com\nDOMAIN,chat.openai.com\nDOMAIN-WILDCARD,*.ai?.net\nIP-CIDR,1.2.3.0/24"
+	if output != want {
+		t.Errorf("surge Render() = %q, want %q", output, want)
+	}
+}
@@ -0,0 +1,62 @@
+// Package converter handles the conversion of v2fly domain list format to ruleset formats.
+package converter
+
+// Exclude removes the rules of items whose domains are all matched by a rule
+// of other: excluding a suffix also removes the domains and suffixes under
+// it. Rules only partly covered, such as a suffix above an excluded one, are
+// kept since the formats cannot express the difference. It returns the
+// remaining items and the number of rules removed.
+func Exclude(items, other []Item) ([]Item, int) {
+	idx := newRuleIndex(other)
+	result := make([]Item, 0, len(items))
+	removed := 0
+
+	for _, item := range items {
+		if item.Kind == ItemRule && item.Rule != nil && idx.covers(*item.Rule) {
+			removed++
+			continue
+		}
+		result = append(result, item)
+	}
+
+	return result, removed
+}
+
+// Intersect keeps the rules of items covered by a rule of other, and adds
+// the rules of other covered by a rule of items, so that the intersection of
+// a suffix with a domain under it is that domain. It returns the resulting
+// items and the number of rules of items removed.
+func Intersect(items, other []Item) ([]Item, int) {
+	otherIdx := newRuleIndex(other)
+	itemsIdx := newRuleIndex(items)
+	seen := make(map[ruleKey]bool)
+	result := make([]Item, 0, len(items))
+	removed := 0
+
+	for _, item := range items {
+		if item.Kind != ItemRule || item.Rule == nil {
+			result = append(result, item)
+			continue
+		}
+		if !otherIdx.covers(*item.Rule) {
+			removed++
+			continue
+		}
+		seen[keyOf(*item.Rule)] = true
+		result = append(result, item)
+	}
+
+	for _, item := range other {
+		if item.Kind != ItemRule || item.Rule == nil {
+			continue
+		}
+		key := keyOf(*item.Rule)
+		if seen[key] || !itemsIdx.covers(*item.Rule) {
+			continue
+		}
+		seen[key] = true
+		result = append(result, item)
+	}
+
+	return result, removed
+}
@@ -0,0 +1,35 @@
+package converter_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/xxxbrian/surge-geosite/internal/converter"
+)
+
+func ruleValues(items []converter.Item) []string {
+	var values []string
+	for _, item := range items {
+		if item.Kind == converter.ItemRule {
+			values = append(values, item.Rule.Value)
+		}
+	}
+	return values
+}
+
+func TestSetOperations(t *testing.T) {
+	conv := converter.NewConverter(nil)
+	base, _ := conv.Parse("domain:google.com\nfull:mail.google.com\ndomain:youtube.com\nkeyword:googlevideo\ndomain:example.org\nregexp:^a\\.b$", converter.Filter{})
+	other, _ := conv.Parse("domain:youtube.com\ndomain:mail.google.com\nkeyword:video\nfull:www.example.org\nregexp:^a\\.b$", converter.Filter{})
+
+	excluded, removed := converter.Exclude(base, other)
+	if got, want := fmt.Sprint(ruleValues(excluded)), "[google.com example.org]"; got != want || removed != 4 {
+		t.Errorf("Exclude() = %s (removed %d), want %s (removed 4)", got, removed, want)
+	}
+
+	intersected, removed := converter.Intersect(base, other)
+	want := "[mail.google.com youtube.com googlevideo ^a\\.b$ mail.google.com www.example.org]"
+	if got := fmt.Sprint(ruleValues(intersected)); got != want || removed != 2 {
+		t.Errorf("Intersect() = %s (removed %d), want %s (removed 2)", got, removed, want)
+	}
+}
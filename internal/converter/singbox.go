@@ -0,0 +1,52 @@
+// Package converter handles the conversion of v2fly domain list format to ruleset formats.
+package converter
+
+import "github.com/xxxbrian/surge-geosite/internal/singbox"
+
+// SingBoxRuleSet collects parsed rules into a sing-box rule-set.
+// sing-box matches regexes with Go's regexp package, so regex rules are kept as is.
+func SingBoxRuleSet(items []Item) *singbox.RuleSet {
+	rs := &singbox.RuleSet{}
+	for _, item := range items {
+		if item.Kind != ItemRule || item.Rule == nil {
+			continue
+		}
+		switch item.Rule.Kind {
+		case RuleDomain:
+			rs.Domain = append(rs.Domain, item.Rule.Value)
+		case RuleDomainSuffix:
+			rs.DomainSuffix = append(rs.DomainSuffix, item.Rule.Value)
+		case RuleDomainKeyword:
+			rs.DomainKeyword = append(rs.DomainKeyword, item.Rule.Value)
+		case RuleDomainRegex:
+			rs.DomainRegex = append(rs.DomainRegex, item.Rule.Value)
+		case RuleIPCIDR:
+			rs.IPCIDR = append(rs.IPCIDR, item.Rule.Value)
+		}
+	}
+	return rs
+}
+
+// RenderSingBox renders parsed items into a sing-box source rule-set (JSON).
+func RenderSingBox(items []Item) (string, error) {
+	data, err := SingBoxRuleSet(items).MarshalSource()
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func init() {
+	support := supportOf(RuleDomainSuffix, RuleDomain, RuleDomainKeyword, RuleDomainRegex, RuleIPCIDR)
+	RegisterRenderer(NewRenderer("sing-box", ContentTypeJSON, support, fallible(RenderSingBox)))
+	RegisterRenderer(NewRenderer("sing-box-srs", ContentTypeBinary, support, fallible(RenderSingBoxBinary)))
+}
+
+// RenderSingBoxBinary renders parsed items into a sing-box binary rule-set (.srs).
+func RenderSingBoxBinary(items []Item) (string, error) {
+	data, err := SingBoxRuleSet(items).MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -1,6 +1,8 @@
 // Package converter handles the conversion of v2fly domain list format to ruleset formats.
 package converter
 
+import "strings"
+
 // ItemKind represents the kind of parsed item.
 type ItemKind int
 
@@ -17,12 +19,15 @@ const (
 	RuleDomain
 	RuleDomainKeyword
 	RuleDomainRegex
+	// RuleIPCIDR matches an IPv4 or IPv6 prefix.
+	RuleIPCIDR
 )
 
 // Rule represents a parsed rule line with optional comment.
 type Rule struct {
 	Kind    RuleKind
 	Value   string
+	Attrs   []string
 	Comment string
 }
 
@@ -32,3 +37,17 @@ type Item struct {
 	Rule    *Rule
 	Comment string
 }
+
+// isIPv6 reports whether an IP CIDR rule holds an IPv6 prefix.
+func (r Rule) isIPv6() bool {
+	return strings.Contains(r.Value, ":")
+}
+
+// IPCIDRItems wraps CIDRs into IP CIDR rule items.
+func IPCIDRItems(cidrs []string) []Item {
+	items := make([]Item, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		items = append(items, Item{Kind: ItemRule, Rule: &Rule{Kind: RuleIPCIDR, Value: cidr}})
+	}
+	return items
+}
@@ -0,0 +1,85 @@
+// Package converter handles the conversion of v2fly domain list format to ruleset formats.
+package converter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xxxbrian/surge-geosite/internal/v2ray"
+)
+
+// V2RayGeoSite collects parsed rules into a geosite entry named code,
+// keeping the attributes of each rule. Exact duplicates are dropped.
+func V2RayGeoSite(code string, items []Item) v2ray.GeoSite {
+	site := v2ray.GeoSite{CountryCode: strings.ToUpper(code)}
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if item.Kind != ItemRule || item.Rule == nil {
+			continue
+		}
+		domain := v2ray.Domain{Value: item.Rule.Value, Attrs: item.Rule.Attrs}
+		switch item.Rule.Kind {
+		case RuleDomainSuffix:
+			domain.Type = v2ray.DomainRoot
+		case RuleDomain:
+			domain.Type = v2ray.DomainFull
+		case RuleDomainKeyword:
+			domain.Type = v2ray.DomainPlain
+		case RuleDomainRegex:
+			domain.Type = v2ray.DomainRegex
+		default:
+			continue
+		}
+		key := fmt.Sprint(domain.Type, domain.Value, domain.Attrs)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		site.Domains = append(site.Domains, domain)
+	}
+	return site
+}
+
+// BuildGeoSiteDat parses the named upstream lists with their includes
+// expanded and encodes them into a v2ray geosite.dat file.
+func (c *Converter) BuildGeoSiteDat(names []string) ([]byte, error) {
+	sites := make([]v2ray.GeoSite, 0, len(names))
+	for _, name := range names {
+		content, err := c.lists.ReadList(name)
+		if err != nil {
+			return nil, err
+		}
+		items, err := c.Parse(content, Filter{})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		sites = append(sites, V2RayGeoSite(name, items))
+	}
+	return v2ray.MarshalGeoSiteList(sites), nil
+}
+
+// ParseListNames parses a list of upstream list names separated by sep,
+// returning them lowercased, deduplicated and sorted.
+func ParseListNames(raw string, sep string) ([]string, error) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range strings.Split(raw, sep) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if strings.ContainsAny(name, "/\\@+,") || strings.HasPrefix(name, ".") {
+			return nil, fmt.Errorf("invalid list name %q", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no list names given")
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -1,138 +1,283 @@
-// Package fetcher handles downloading and extracting the domain-list-community ZIP file.
+// Package fetcher provides the domain-list-community lists, downloaded as a ZIP file or read from a local directory.
 package fetcher
 
 import (
 	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/xxxbrian/surge-geosite/internal/cache"
+	"github.com/xxxbrian/surge-geosite/internal/httpclient"
+	"github.com/xxxbrian/surge-geosite/internal/overlay"
 )
 
 const (
-	zipURL    = "https://github.com/v2fly/domain-list-community/archive/refs/heads/master.zip"
-	userAgent = "Surge-Geosite-Go/1.0"
+	// DefaultZipURL is the v2fly domain-list-community master archive.
+	DefaultZipURL = "https://github.com/v2fly/domain-list-community/archive/refs/heads/master.zip"
+	// maxZipSize bounds a downloaded archive; domain-list-community is a
+	// few megabytes.
+	maxZipSize = 256 << 20
 )
 
+// errNotModified reports that upstream still serves the cached archive.
+var errNotModified = errors.New("not modified")
+
 // Fetcher handles ZIP file operations
 type Fetcher struct {
 	client   *http.Client
+	url      string
 	zipCache *cache.ZipCache
+	overlay  *overlay.Overlay
+	mirrors  []string
+	attempts int
+	backoff  time.Duration
+	minLists int
+
+	// staleMu guards the error and time of the last failed check.
+	staleMu  sync.Mutex
+	staleErr error
+	failedAt time.Time
+
+	// revalMu guards the check of upstream currently running, if any.
+	revalMu  sync.Mutex
+	inflight *revalidation
+
+	// prefixMu guards the data directory detected in prefixReader.
+	prefixMu     sync.Mutex
+	prefixReader *zip.Reader
+	prefix       string
 }
 
-// NewFetcher creates a new Fetcher
-func NewFetcher(zipCache *cache.ZipCache) *Fetcher {
+// NewFetcher creates a new Fetcher downloading the archive at url, or the
+// v2fly archive if url is empty.
+func NewFetcher(url string, zipCache *cache.ZipCache) *Fetcher {
+	if url == "" {
+		url = DefaultZipURL
+	}
 	return &Fetcher{
-		client: &http.Client{
-			Timeout: 60 * time.Second,
-		},
+		client:   httpclient.Default(60 * time.Second),
+		url:      url,
 		zipCache: zipCache,
+		attempts: defaultAttempts,
+		backoff:  defaultBackoff,
+		minLists: defaultMinLists,
 	}
 }
 
-// GetETag fetches the ETag from GitHub without downloading the full file
-func (f *Fetcher) GetETag() (string, error) {
-	req, err := http.NewRequest(http.MethodHead, zipURL, nil)
-	if err != nil {
-		return "", err
+// ArchiveURL expands a GitHub "owner/repo" or "owner/repo@ref" source into
+// the archive URL of the branch, tag or commit ref, or of the default branch
+// when ref is omitted. Other values are returned unchanged.
+func ArchiveURL(source string) string {
+	if strings.Contains(source, "://") {
+		return source
+	}
+	repo, ref, ok := strings.Cut(source, "@")
+	if !ok || ref == "" {
+		ref = "HEAD"
 	}
-	req.Header.Set("User-Agent", userAgent)
+	return "https://github.com/" + strings.Trim(repo, "/") + "/archive/" + ref + ".zip"
+}
 
-	resp, err := f.client.Do(req)
+// URL returns the archive URL of the fetcher.
+func (f *Fetcher) URL() string {
+	return f.url
+}
+
+// SetHTTPClient sets the client archives are downloaded with.
+func (f *Fetcher) SetHTTPClient(client *http.Client) {
+	f.client = client
+}
+
+// SetOverlay makes the lists of o add to and patch the upstream lists. The
+// overlay version becomes part of the returned ETags so cached results are
+// invalidated when the overlay changes.
+func (f *Fetcher) SetOverlay(o *overlay.Overlay) {
+	f.overlay = o
+}
+
+// Lists returns the lists of the cached or freshly downloaded archive.
+func (f *Fetcher) Lists() (Lists, string, error) {
+	reader, etag, err := f.GetZipReader()
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
-	defer resp.Body.Close()
+	return f.zipLists(reader), etag, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HEAD request failed: %s", resp.Status)
+// Refresh checks upstream for updates regardless of TTL.
+func (f *Fetcher) Refresh() (Lists, string, error) {
+	reader, etag, err := f.RefreshZipReader()
+	if err != nil {
+		return nil, "", err
 	}
+	return f.zipLists(reader), etag, nil
+}
 
-	etag := resp.Header.Get("ETag")
-	// Clean up ETag (remove quotes and W/ prefix if present)
-	etag = strings.ReplaceAll(etag, "\"", "")
-	etag = strings.TrimPrefix(etag, "W/")
+// zipLists returns the lists of reader patched by the overlay.
+func (f *Fetcher) zipLists(reader *zip.Reader) Lists {
+	return withOverlay(zipLists{reader: reader, prefix: f.dataPrefix(reader)}, f.overlay)
+}
 
-	return etag, nil
+// cleanETag removes quotes and the W/ prefix from an ETag.
+func cleanETag(etag string) string {
+	etag = strings.ReplaceAll(etag, "\"", "")
+	return strings.TrimPrefix(etag, "W/")
 }
 
 // GetZipReader returns a cached or freshly downloaded zip.Reader
 func (f *Fetcher) GetZipReader() (*zip.Reader, string, error) {
+	reader, etag, err := f.getZipReader()
+	return reader, overlayETag(etag, f.overlay), err
+}
+
+func (f *Fetcher) getZipReader() (*zip.Reader, string, error) {
+	if f.Pinned() != "" {
+		return f.pinnedZipReader()
+	}
+
 	// Try cache first
 	reader, etag, ok := f.zipCache.Get()
 	if ok {
 		return reader, etag, nil
 	}
 
-	// Check if ETag changed
-	newETag, err := f.GetETag()
-	if err != nil {
-		// If we have cached data, use it even if ETag check failed
-		if reader != nil {
-			return reader, etag, nil
+	// Serve the expired snapshot while it is revalidated in the background,
+	// unless upstream just failed
+	reader, etag, _ = f.zipCache.GetAny()
+	if reader != nil {
+		if !f.coolingDown() {
+			f.startRevalidation()
 		}
-		return nil, "", fmt.Errorf("failed to get ETag: %w", err)
-	}
-
-	// If ETag hasn't changed and we have valid cached reader
-	if etag == newETag && reader != nil {
 		return reader, etag, nil
 	}
+	return f.startRevalidation().wait()
+}
 
-	// Download new ZIP
-	data, err := f.downloadZip()
-	if err != nil {
-		return nil, "", err
-	}
+// RefreshZipReader checks upstream for updates regardless of TTL.
+func (f *Fetcher) RefreshZipReader() (*zip.Reader, string, error) {
+	reader, etag, err := f.refreshZipReader()
+	return reader, overlayETag(etag, f.overlay), err
+}
 
-	// Update cache
-	if err := f.zipCache.Set(data, newETag); err != nil {
-		return nil, "", fmt.Errorf("failed to set cache: %w", err)
+func (f *Fetcher) refreshZipReader() (*zip.Reader, string, error) {
+	if f.Pinned() != "" {
+		return f.pinnedZipReader()
 	}
 
-	reader, _, _ = f.zipCache.Get()
-	return reader, newETag, nil
+	return f.startRevalidation().wait()
 }
 
-// RefreshZipReader checks upstream for updates regardless of TTL.
-func (f *Fetcher) RefreshZipReader() (*zip.Reader, string, error) {
-	reader, etag, _ := f.zipCache.GetAny()
+// revalidation is a check of upstream shared by concurrent callers.
+type revalidation struct {
+	done   chan struct{}
+	reader *zip.Reader
+	etag   string
+	err    error
+}
 
-	newETag, err := f.GetETag()
-	if err != nil {
-		if reader != nil {
-			return reader, etag, nil
-		}
-		return nil, "", fmt.Errorf("failed to get ETag: %w", err)
+// wait returns the result of the check once it finished.
+func (rv *revalidation) wait() (*zip.Reader, string, error) {
+	<-rv.done
+	return rv.reader, rv.etag, rv.err
+}
+
+// startRevalidation starts checking upstream in the background unless a
+// check is already running, and returns the running check.
+func (f *Fetcher) startRevalidation() *revalidation {
+	f.revalMu.Lock()
+	defer f.revalMu.Unlock()
+	if f.inflight != nil {
+		return f.inflight
 	}
 
-	if etag == newETag && reader != nil {
+	rv := &revalidation{done: make(chan struct{})}
+	f.inflight = rv
+	go func() {
+		reader, etag, _ := f.zipCache.GetAny()
+		rv.reader, rv.etag, rv.err = f.revalidate(reader, etag)
+
+		f.revalMu.Lock()
+		f.inflight = nil
+		f.revalMu.Unlock()
+		close(rv.done)
+	}()
+	return rv
+}
+
+// revalidate downloads the archive with a conditional GET against the
+// cached snapshot, keeping the snapshot when upstream reports it unchanged.
+// When upstream and its mirrors fail, the snapshot is served stale.
+func (f *Fetcher) revalidate(reader *zip.Reader, etag string) (*zip.Reader, string, error) {
+	a, err := f.fetchArchive(f.url, reader != nil)
+	if errors.Is(err, errNotModified) {
+		f.setStale(nil)
+		if !f.zipCache.TouchUnlessPinned() {
+			// Pinned while checking; keep the pinned snapshot.
+			return f.pinnedZipReader()
+		}
 		return reader, etag, nil
 	}
-
-	data, err := f.downloadZip()
 	if err != nil {
+		f.setStale(err)
+		// If we have cached data, use it even if the download failed
+		if reader != nil {
+			log.Printf("Serving stale snapshot %s: %v", etag, err)
+			return reader, etag, nil
+		}
 		return nil, "", err
 	}
+	f.setStale(nil)
 
-	if err := f.zipCache.Set(data, newETag); err != nil {
+	// Update cache
+	ok, err := f.zipCache.SetUnlessPinned(a.data, a.etag, a.url, a.validators)
+	if err != nil {
 		return nil, "", fmt.Errorf("failed to set cache: %w", err)
 	}
+	if !ok {
+		// Pinned while downloading; keep the pinned snapshot.
+		return f.pinnedZipReader()
+	}
 
-	reader, _, _ = f.zipCache.GetAny()
-	return reader, newETag, nil
+	// The cache keeps its ETag when the archive is unchanged.
+	reader, etag, _ = f.zipCache.GetAny()
+	return reader, etag, nil
 }
 
-// downloadZip downloads the ZIP file from GitHub
-func (f *Fetcher) downloadZip() ([]byte, error) {
-	req, err := http.NewRequest(http.MethodGet, zipURL, nil)
+// archive is a downloaded ZIP file with the URL that served it and the
+// validators of its response.
+type archive struct {
+	data []byte
+	// etag identifies the snapshot: the response ETag, or a hash of data
+	// when upstream sent none.
+	etag       string
+	url        string
+	validators cache.Validators
+}
+
+// downloadZip downloads the ZIP file at url. When cached carries validators
+// the request is conditional, and errNotModified is returned if upstream
+// still serves the same file. The body is limited to maxZipSize bytes.
+func (f *Fetcher) downloadZip(url string, cached cache.Validators) (*archive, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", userAgent)
+	if cached.ETag != "" {
+		req.Header.Set("If-None-Match", `"`+cached.ETag+`"`)
+	}
+	if cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
 
 	resp, err := f.client.Do(req)
 	if err != nil {
@@ -140,22 +285,205 @@ func (f *Fetcher) downloadZip() ([]byte, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, errNotModified
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("download failed: %s", resp.Status)
+		return nil, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	if resp.ContentLength > maxZipSize {
+		return nil, fmt.Errorf("%w: %d bytes", errTooLarge, resp.ContentLength)
 	}
 
-	data, err := io.ReadAll(resp.Body)
+	var buf bytes.Buffer
+	if resp.ContentLength > 0 {
+		buf.Grow(int(resp.ContentLength))
+	}
+	n, err := buf.ReadFrom(io.LimitReader(resp.Body, maxZipSize+1))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
+	if n > maxZipSize {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", errTooLarge, maxZipSize)
+	}
+
+	a := &archive{
+		data: buf.Bytes(),
+		url:  url,
+		validators: cache.Validators{
+			ETag:         cleanETag(resp.Header.Get("ETag")),
+			LastModified: resp.Header.Get("Last-Modified"),
+		},
+	}
+	a.etag = a.validators.ETag
+	if a.etag == "" {
+		// Cached results are keyed by ETag, so derive one from the content.
+		sum := sha256.Sum256(a.data)
+		a.etag = "sha256-" + hex.EncodeToString(sum[:8])
+	}
+	return a, nil
+}
 
-	return data, nil
+// Revision returns the current or a past snapshot selected by rev, its
+// ETag or a prefix of its commit.
+func (f *Fetcher) Revision(rev string) (Lists, string, error) {
+	reader, etag, ok := f.zipCache.Revision(upstreamETag(rev))
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnknownRevision, rev)
+	}
+	return f.zipLists(reader), overlayETag(etag, f.overlay), nil
 }
 
-// GetFileContent reads a file from the ZIP archive
-func (f *Fetcher) GetFileContent(reader *zip.Reader, name string) (string, error) {
-	filePath := fmt.Sprintf("domain-list-community-master/data/%s", name)
+// Snapshots describes the current and the past snapshots kept in the
+// cache.
+func (f *Fetcher) Snapshots() []cache.Snapshot {
+	return f.zipCache.Snapshots()
+}
+
+// Pinned returns the ref the fetcher is pinned to, or "" when it follows
+// upstream.
+func (f *Fetcher) Pinned() string {
+	return f.zipCache.Pin()
+}
+
+// pinnedZipReader returns the cached snapshot without checking upstream.
+func (f *Fetcher) pinnedZipReader() (*zip.Reader, string, error) {
+	reader, etag, ok := f.zipCache.GetAny()
+	if !ok {
+		return nil, "", fmt.Errorf("pinned to %s but no snapshot is cached", f.Pinned())
+	}
+	return reader, etag, nil
+}
+
+// Pin downloads the archive of ref, a tag, commit or archive URL, and
+// serves it without checking upstream until Unpin is called. A ref of
+// "snapshot:<etag>" restores a kept snapshot instead.
+func (f *Fetcher) Pin(ref string) error {
+	if etag, ok := strings.CutPrefix(ref, "snapshot:"); ok {
+		if err := f.zipCache.RestorePinned(upstreamETag(etag), ref); err != nil {
+			return err
+		}
+		f.setStale(nil)
+		return nil
+	}
+	url, err := f.refURL(ref)
+	if err != nil {
+		return err
+	}
+	a, err := f.fetchArchive(url, false)
+	if err != nil {
+		return err
+	}
+	if err := f.zipCache.SetPinned(a.data, a.etag, a.url, a.validators, ref); err != nil {
+		return fmt.Errorf("failed to set cache: %w", err)
+	}
+	f.setStale(nil)
+	return nil
+}
+
+// Unpin resumes following upstream and fetches its latest snapshot.
+func (f *Fetcher) Unpin() error {
+	if err := f.zipCache.SetPin(""); err != nil {
+		return err
+	}
+	_, _, err := f.refreshZipReader()
+	return err
+}
+
+// Rollback restores the previous snapshot and pins the fetcher to it, so
+// the next refresh does not replace it. It returns the restored ETag.
+func (f *Fetcher) Rollback() (string, error) {
+	snapshots := f.zipCache.Snapshots()
+	if len(snapshots) < 2 {
+		return "", errors.New("no previous snapshot")
+	}
+	etag := snapshots[1].ETag
+	if err := f.zipCache.RestorePinned(etag, "snapshot:"+etag); err != nil {
+		return "", err
+	}
+	f.setStale(nil)
+	return etag, nil
+}
+
+// refURL returns the archive URL of ref in the repository of the fetcher.
+// Refs containing "://" are archive URLs themselves.
+func (f *Fetcher) refURL(ref string) (string, error) {
+	if ref == "" || strings.ContainsAny(ref, " ?#") {
+		return "", fmt.Errorf("invalid ref %q", ref)
+	}
+	if strings.Contains(ref, "://") {
+		return ref, nil
+	}
+	rest, ok := strings.CutPrefix(f.url, "https://github.com/")
+	parts := strings.SplitN(rest, "/", 3)
+	if !ok || len(parts) < 3 || !strings.HasPrefix(parts[2], "archive/") {
+		return "", fmt.Errorf("cannot derive the archive of %s from %s, pin an archive URL instead", ref, f.url)
+	}
+	return ArchiveURL(parts[0] + "/" + parts[1] + "@" + ref), nil
+}
+
+// zipLists reads the lists below prefix in a ZIP archive.
+type zipLists struct {
+	reader *zip.Reader
+	prefix string
+}
+
+// ReadList reads a list from the ZIP archive
+func (l zipLists) ReadList(name string) (string, error) {
+	return readZipFile(l.reader, l.prefix+name)
+}
+
+// Names returns the sorted names of the lists in the archive.
+func (l zipLists) Names() []string {
+	var names []string
+	for _, file := range l.reader.File {
+		name, ok := strings.CutPrefix(file.Name, l.prefix)
+		if ok && name != "" && !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Commit returns the commit recorded in the archive comment.
+func (l zipLists) Commit() string {
+	return cache.ArchiveCommit(l.reader)
+}
+
+// dataPrefix returns the path of the data directory inside the archive,
+// such as "domain-list-community-master/data/". Archives of forks, tags and
+// commits use different root directories, so it is detected once per reader.
+func (f *Fetcher) dataPrefix(reader *zip.Reader) string {
+	f.prefixMu.Lock()
+	defer f.prefixMu.Unlock()
+	if f.prefixReader == reader {
+		return f.prefix
+	}
+
+	prefix, _ := detectDataPrefix(reader)
+	f.prefixReader = reader
+	f.prefix = prefix
+	return prefix
+}
+
+// detectDataPrefix returns the path of the data directory inside the
+// archive and whether one was found, defaulting to "data/".
+func detectDataPrefix(reader *zip.Reader) (string, bool) {
+	for _, file := range reader.File {
+		if strings.HasPrefix(file.Name, "data/") {
+			return "data/", true
+		}
+		parts := strings.SplitN(file.Name, "/", 3)
+		if len(parts) == 3 && parts[1] == "data" {
+			return parts[0] + "/data/", true
+		}
+	}
+	return "data/", false
+}
 
+// readZipFile reads a file from the ZIP archive
+func readZipFile(reader *zip.Reader, filePath string) (string, error) {
 	for _, file := range reader.File {
 		if file.Name == filePath {
 			rc, err := file.Open()
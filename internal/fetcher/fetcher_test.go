@@ -0,0 +1,325 @@
+package fetcher_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xxxbrian/surge-geosite/internal/cache"
+	"github.com/xxxbrian/surge-geosite/internal/fetcher"
+)
+
+func TestArchiveURL(t *testing.T) {
+	tests := map[string]string{
+		"Loyalsoldier/domain-list-custom":        "https://github.com/Loyalsoldier/domain-list-custom/archive/HEAD.zip",
+		"v2fly/domain-list-community@2024010100": "https://github.com/v2fly/domain-list-community/archive/2024010100.zip",
+		"https://example.com/data.zip":           "https://example.com/data.zip",
+	}
+	for source, want := range tests {
+		if got := fetcher.ArchiveURL(source); got != want {
+			t.Errorf("ArchiveURL(%q) = %q, want %q", source, got, want)
+		}
+	}
+}
+
+func TestGetFileContentDetectsRoot(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"domain-list-custom-release/README.md":     "readme",
+		"domain-list-custom-release/data/google":   "google.com",
+		"domain-list-custom-release/data/apple":    "apple.com",
+		"domain-list-custom-release/data/sub/skip": "skip.com",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(w, content)
+	}
+	if err := zw.SetComment("0123456789abcdef0123456789abcdef01234567"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipCache := cache.NewZipCache(time.Hour)
+	if err := zipCache.Set(buf.Bytes(), "etag"); err != nil {
+		t.Fatal(err)
+	}
+
+	lists, etag, err := fetcher.NewFetcher("", zipCache).Lists()
+	if err != nil || etag != "etag" {
+		t.Fatalf("Lists() = %s, %v", etag, err)
+	}
+	if content, err := lists.ReadList("google"); err != nil || content != "google.com" {
+		t.Errorf("ReadList(google) = %q, %v", content, err)
+	}
+	if got := fmt.Sprint(lists.Names()); got != "[apple google]" {
+		t.Errorf("Names() = %s, want [apple google]", got)
+	}
+	if got := lists.Commit(); got != "0123456789abcdef0123456789abcdef01234567" {
+		t.Errorf("Commit() = %q", got)
+	}
+}
+
+func TestLocalSource(t *testing.T) {
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		".git/HEAD":         "ref: refs/heads/master\n",
+		".git/packed-refs":  "# pack-refs\n0123456789abcdef refs/heads/master\n",
+		"data/google":       "google.com",
+		"data/category-dev": "include:google",
+		"data/.hidden":      "skip",
+		"README.md":         "readme",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, path), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src, err := fetcher.NewLocalSource(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists, etag, err := src.Lists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(etag, "git-0123456789abcdef-") {
+		t.Errorf("ETag = %s, want git HEAD commit prefix", etag)
+	}
+	if got := fmt.Sprint(lists.Names()); got != "[category-dev google]" {
+		t.Errorf("Names() = %s, want [category-dev google]", got)
+	}
+	if content, err := lists.ReadList("google"); err != nil || content != "google.com" {
+		t.Errorf("ReadList(google) = %q, %v", content, err)
+	}
+	if _, err := lists.ReadList("../README.md"); err == nil {
+		t.Error("ReadList(../README.md) escaped the data directory")
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "data", "apple"), []byte("apple.com"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, refreshed, err := src.Refresh(); err != nil || refreshed == etag {
+		t.Errorf("Refresh() = %s, %v, want a new ETag", refreshed, err)
+	}
+}
+
+func TestConditionalRefresh(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("repo-main/data/google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(w, "google.com")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected %s request", r.Method)
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `W/"v1"`)
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	f := fetcher.NewFetcher(srv.URL, cache.NewZipCache(time.Nanosecond))
+	f.SetMinLists(1)
+	for _, get := range []func() (fetcher.Lists, string, error){f.Lists, f.Refresh} {
+		lists, etag, err := get()
+		if err != nil || etag != "v1" {
+			t.Fatalf("got %s, %v, want v1", etag, err)
+		}
+		if content, err := lists.ReadList("google"); err != nil || content != "google.com" {
+			t.Errorf("ReadList(google) = %q, %v", content, err)
+		}
+	}
+	if requests != 2 || notModified != 1 {
+		t.Errorf("requests = %d, not modified = %d, want 2 and 1", requests, notModified)
+	}
+}
+
+func TestMirrorsAndStaleSnapshot(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"apple", "google"} {
+		w, err := zw.Create("repo-main/data/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(w, name+".com")
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var primary, mirror, notModified int
+	var primaryUp bool
+	mirrorBody := buf.Bytes()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inm := r.Header.Get("If-None-Match")
+		if r.URL.Path == "/primary.zip" {
+			primary++
+			if strings.HasPrefix(inm, `"m`) {
+				t.Errorf("primary was asked with the mirror validator %s", inm)
+			}
+			switch {
+			case !primaryUp:
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			case r.Header.Get("If-None-Match") == `"p1"`:
+				notModified++
+				w.WriteHeader(http.StatusNotModified)
+			default:
+				w.Header().Set("ETag", `"p1"`)
+				w.Write(buf.Bytes())
+			}
+			return
+		}
+		if inm == `"p1"` {
+			t.Error("mirror was asked with the primary validator")
+		}
+		mirror++
+		w.Header().Set("ETag", fmt.Sprintf(`"m%d"`, mirror))
+		w.Write(mirrorBody)
+	}))
+	defer srv.Close()
+
+	f := fetcher.NewFetcher(srv.URL+"/primary.zip", cache.NewZipCache(time.Nanosecond))
+	f.SetMirrors([]string{srv.URL + "/copy.zip"})
+	f.SetRetry(2, time.Millisecond)
+	f.SetMinLists(2)
+
+	lists, etag, err := f.Refresh()
+	if err != nil || etag != "m1" {
+		t.Fatalf("Refresh() = %s, %v, want m1 from the mirror", etag, err)
+	}
+	if primary != 2 || mirror != 1 {
+		t.Errorf("primary = %d, mirror = %d requests, want 2 and 1", primary, mirror)
+	}
+	if content, err := lists.ReadList("apple"); err != nil || content != "apple.com" {
+		t.Errorf("ReadList(apple) = %q, %v", content, err)
+	}
+
+	// An invalid archive must not replace the snapshot.
+	mirrorBody = []byte("not a zip")
+	if _, etag, err := f.Refresh(); err != nil || etag != "m1" {
+		t.Errorf("Refresh() = %s, %v, want stale m1", etag, err)
+	}
+	if f.Stale() == nil {
+		t.Error("Stale() = nil after a failed refresh")
+	}
+
+	// The same archive from another URL keeps the snapshot and its ETag.
+	mirrorBody = buf.Bytes()
+	if _, etag, err := f.Refresh(); err != nil || etag != "m1" || f.Stale() != nil {
+		t.Errorf("Refresh() = %s, %v, stale %v, want fresh m1", etag, err, f.Stale())
+	}
+
+	// Once the primary is back it confirms the archive with its own ETag.
+	primaryUp = true
+	for range 2 {
+		if _, etag, err := f.Refresh(); err != nil || etag != "m1" {
+			t.Errorf("Refresh() = %s, %v, want m1", etag, err)
+		}
+	}
+	if notModified != 1 {
+		t.Errorf("primary answered %d conditional requests with 304, want 1", notModified)
+	}
+
+	// A mirror serving the archive in between does not cost the primary its
+	// validator.
+	primaryUp = false
+	if _, etag, err := f.Refresh(); err != nil || etag != "m1" {
+		t.Errorf("Refresh() = %s, %v, want m1 from the mirror", etag, err)
+	}
+	primaryUp = true
+	if _, etag, err := f.Refresh(); err != nil || etag != "m1" || notModified != 2 {
+		t.Errorf("Refresh() = %s, %v with %d 304s, want m1 confirmed by the primary", etag, err, notModified)
+	}
+	if snaps := f.Snapshots(); len(snaps) != 1 {
+		t.Errorf("Snapshots() = %+v, want only the current snapshot", snaps)
+	}
+}
+
+func TestBackgroundRevalidation(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("repo-main/data/google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(w, "google.com")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	var old bytes.Buffer
+	zw = zip.NewWriter(&old)
+	if _, err := zw.Create("repo-main/data/google"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipCache := cache.NewZipCache(time.Nanosecond)
+	if err := zipCache.Set(old.Bytes(), "v0"); err != nil {
+		t.Fatal(err)
+	}
+	f := fetcher.NewFetcher(srv.URL, zipCache)
+	f.SetMinLists(1)
+
+	// Expired requests get the current snapshot while upstream is blocked.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, etag, err := f.Lists(); err != nil || etag != "v0" {
+				t.Errorf("Lists() = %s, %v, want v0", etag, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	close(release)
+	if _, etag, err := f.Refresh(); err != nil || etag != "v1" {
+		t.Errorf("Refresh() = %s, %v, want v1", etag, err)
+	}
+	if n := requests.Load(); n > 2 {
+		t.Errorf("%d upstream requests, want the expired requests coalesced", n)
+	}
+}
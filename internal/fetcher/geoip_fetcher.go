@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/xxxbrian/surge-geosite/internal/cache"
+	"github.com/xxxbrian/surge-geosite/internal/httpclient"
 )
 
 const (
@@ -24,14 +25,17 @@ func NewGeoIPFetcher(url string) *GeoIPFetcher {
 		url = DefaultGeoIPURL
 	}
 	return &GeoIPFetcher{
-		client: &http.Client{
-			Timeout: 60 * time.Second,
-		},
-		url:   url,
-		cache: cache.NewZipCache(24 * time.Hour), // 24h caching
+		client: httpclient.Default(60 * time.Second),
+		url:    url,
+		cache:  cache.NewZipCache(24 * time.Hour), // 24h caching
 	}
 }
 
+// SetHTTPClient sets the client the DB is downloaded with.
+func (f *GeoIPFetcher) SetHTTPClient(client *http.Client) {
+	f.client = client
+}
+
 // GetDB returns the cached or freshly downloaded DB bytes
 func (f *GeoIPFetcher) GetDB() ([]byte, error) {
 	// Try cache first
@@ -47,7 +51,6 @@ func (f *GeoIPFetcher) download() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", userAgent)
 
 	resp, err := f.client.Do(req)
 	if err != nil {
@@ -0,0 +1,233 @@
+package fetcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xxxbrian/surge-geosite/internal/cache"
+	"github.com/xxxbrian/surge-geosite/internal/overlay"
+)
+
+// localCheckInterval bounds how often a LocalSource re-reads the directory
+// to compute its ETag.
+const localCheckInterval = 2 * time.Second
+
+// LocalSource serves the lists of a local domain-list-community checkout or
+// of a bare data directory, for development and air-gapped deployments.
+type LocalSource struct {
+	root    string
+	dataDir string
+	overlay *overlay.Overlay
+
+	mu      sync.Mutex
+	etag    string
+	checked time.Time
+}
+
+// NewLocalSource serves the lists in dir, either a checkout containing a
+// data directory or the data directory itself.
+func NewLocalSource(dir string) (*LocalSource, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	dataDir := filepath.Join(root, "data")
+	if info, err := os.Stat(dataDir); err != nil || !info.IsDir() {
+		dataDir = root
+	}
+	if info, err := os.Stat(dataDir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dataDir)
+	}
+	return &LocalSource{root: root, dataDir: dataDir}, nil
+}
+
+// SetOverlay makes the lists of o add to and patch the local lists.
+func (s *LocalSource) SetOverlay(o *overlay.Overlay) {
+	s.overlay = o
+}
+
+// URL returns the data directory.
+func (s *LocalSource) URL() string {
+	return s.dataDir
+}
+
+// Lists returns the local lists. The ETag is the git HEAD commit of the
+// checkout, when there is one, followed by a hash of the file sizes and
+// modification times, so uncommitted edits also invalidate cached results.
+func (s *LocalSource) Lists() (Lists, string, error) {
+	return s.lists(false)
+}
+
+// Refresh recomputes the ETag immediately.
+func (s *LocalSource) Refresh() (Lists, string, error) {
+	return s.lists(true)
+}
+
+func (s *LocalSource) lists(force bool) (Lists, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if force || s.etag == "" || time.Since(s.checked) > localCheckInterval {
+		etag, err := s.computeETag()
+		if err != nil {
+			return nil, "", err
+		}
+		s.etag = etag
+		s.checked = time.Now()
+	}
+	return withOverlay(dirLists(s.dataDir), s.overlay), overlayETag(s.etag, s.overlay), nil
+}
+
+func (s *LocalSource) computeETag() (string, error) {
+	entries, err := os.ReadDir(s.dataDir)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s %d %d\n", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	if commit := gitHead(s.root); commit != "" {
+		return "git-" + commit + "-" + sum[:8], nil
+	}
+	return "dir-" + sum[:16], nil
+}
+
+// Pinned reports that local sources are never pinned.
+func (s *LocalSource) Pinned() string { return "" }
+
+// Pin is not supported; check out the ref instead.
+func (s *LocalSource) Pin(string) error { return errPinUnsupported }
+
+// Unpin is not supported.
+func (s *LocalSource) Unpin() error { return errPinUnsupported }
+
+// Rollback is not supported.
+func (s *LocalSource) Rollback() (string, error) { return "", errPinUnsupported }
+
+// Stale reports that local sources are always current.
+func (s *LocalSource) Stale() error { return nil }
+
+// Revision returns the lists when rev is the current ETag; past states of
+// the directory are not kept.
+func (s *LocalSource) Revision(rev string) (Lists, string, error) {
+	lists, etag, err := s.Lists()
+	if err != nil {
+		return nil, "", err
+	}
+	if upstreamETag(rev) != upstreamETag(etag) {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnknownRevision, rev)
+	}
+	return lists, etag, nil
+}
+
+// Snapshots describes the current state of the directory.
+func (s *LocalSource) Snapshots() []cache.Snapshot {
+	_, etag, err := s.Lists()
+	if err != nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return []cache.Snapshot{{ETag: upstreamETag(etag), Commit: gitHead(s.root), FetchedAt: s.checked, Current: true}}
+}
+
+// gitHead returns the HEAD commit of the git checkout at root, or "" when
+// root is not a checkout. It reads the repository files directly so no git
+// binary is needed.
+func gitHead(root string) string {
+	gitDir := filepath.Join(root, ".git")
+	if info, err := os.Stat(gitDir); err != nil {
+		return ""
+	} else if !info.IsDir() {
+		// Worktrees and submodules point to the repository in a .git file.
+		content, err := os.ReadFile(gitDir)
+		if err != nil {
+			return ""
+		}
+		dir, ok := strings.CutPrefix(strings.TrimSpace(string(content)), "gitdir: ")
+		if !ok {
+			return ""
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		gitDir = dir
+	}
+
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	ref, ok := strings.CutPrefix(strings.TrimSpace(string(head)), "ref: ")
+	if !ok {
+		return strings.TrimSpace(string(head))
+	}
+	if commit, err := os.ReadFile(filepath.Join(gitDir, filepath.FromSlash(ref))); err == nil {
+		return strings.TrimSpace(string(commit))
+	}
+	packed, err := os.ReadFile(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(packed), "\n") {
+		if commit, name, ok := strings.Cut(strings.TrimSpace(line), " "); ok && name == ref {
+			return commit
+		}
+	}
+	return ""
+}
+
+// dirLists reads the lists of a data directory.
+type dirLists string
+
+// ReadList reads a list file.
+func (d dirLists) ReadList(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid list name: %q", name)
+	}
+	content, err := os.ReadFile(filepath.Join(string(d), name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("file not found: %s", name)
+		}
+		return "", err
+	}
+	return string(content), nil
+}
+
+// Names returns the sorted names of the list files.
+func (d dirLists) Names() []string {
+	entries, err := os.ReadDir(string(d))
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Commit returns "": a data directory does not record a commit.
+func (d dirLists) Commit() string {
+	return ""
+}
@@ -0,0 +1,171 @@
+package fetcher
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xxxbrian/surge-geosite/internal/cache"
+)
+
+const (
+	// defaultAttempts is how often each archive URL is tried.
+	defaultAttempts = 3
+	// defaultBackoff is the delay before the first retry; it doubles with
+	// every further retry.
+	defaultBackoff = time.Second
+	// defaultMinLists is the number of lists a downloaded archive must hold
+	// to replace the cached one.
+	defaultMinLists = 10
+	// failureCooldown is how long requests are served the stale snapshot
+	// after a failed check before upstream is tried again.
+	failureCooldown = time.Minute
+)
+
+// errTooLarge reports an archive exceeding maxZipSize.
+var errTooLarge = errors.New("archive too large")
+
+// statusError is an unexpected HTTP status of an archive download.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "download failed: " + e.status
+}
+
+// SetMirrors sets the URLs tried in order when the archive URL fails. A
+// mirror containing "{url}" has it replaced by the archive URL, a mirror
+// ending in "/" is a prefix put in front of it (as with ghproxy), and any
+// other mirror is a full copy of the archive, used for the archive URL of
+// the fetcher only and not for pinned refs.
+func (f *Fetcher) SetMirrors(mirrors []string) {
+	f.mirrors = mirrors
+}
+
+// SetRetry sets how often each archive URL is tried and the delay before
+// the first retry, which doubles with every further retry.
+func (f *Fetcher) SetRetry(attempts int, backoff time.Duration) {
+	f.attempts = max(attempts, 1)
+	f.backoff = backoff
+}
+
+// SetMinLists sets the number of lists a downloaded archive must hold to
+// replace the cached one.
+func (f *Fetcher) SetMinLists(n int) {
+	f.minLists = n
+}
+
+// Stale returns why the last check of upstream failed while an older
+// snapshot is served, or nil when the snapshot is current.
+func (f *Fetcher) Stale() error {
+	f.staleMu.Lock()
+	defer f.staleMu.Unlock()
+	return f.staleErr
+}
+
+// setStale records the outcome of a check of upstream.
+func (f *Fetcher) setStale(err error) {
+	f.staleMu.Lock()
+	defer f.staleMu.Unlock()
+	f.staleErr = err
+	if err != nil {
+		f.failedAt = time.Now()
+	}
+}
+
+// coolingDown reports whether a check of upstream failed recently.
+func (f *Fetcher) coolingDown() bool {
+	f.staleMu.Lock()
+	defer f.staleMu.Unlock()
+	return f.staleErr != nil && time.Since(f.failedAt) < failureCooldown
+}
+
+// mirrorURLs returns url followed by its mirrors.
+func (f *Fetcher) mirrorURLs(url string) []string {
+	urls := []string{url}
+	for _, mirror := range f.mirrors {
+		switch {
+		case strings.Contains(mirror, "{url}"):
+			urls = append(urls, strings.ReplaceAll(mirror, "{url}", url))
+		case strings.HasSuffix(mirror, "/"):
+			urls = append(urls, mirror+url)
+		case url == f.url:
+			urls = append(urls, mirror)
+		}
+	}
+	return urls
+}
+
+// fetchArchive downloads the archive at url, falling back to its mirrors in
+// order, and returns the first download that passes validation. When
+// conditional is set, URLs that served the current snapshot before are
+// asked whether it changed.
+func (f *Fetcher) fetchArchive(url string, conditional bool) (*archive, error) {
+	var errs []error
+	for _, u := range f.mirrorURLs(url) {
+		// Each URL is only asked with the validators it sent itself; an
+		// ETag of one mirror never matches at another.
+		var cached cache.Validators
+		if conditional {
+			cached, _ = f.zipCache.Validators(u)
+		}
+		a, err := f.downloadWithRetry(u, cached)
+		if err == nil || errors.Is(err, errNotModified) {
+			return a, err
+		}
+		log.Printf("Download of %s failed: %v", u, err)
+		errs = append(errs, fmt.Errorf("%s: %w", u, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// downloadWithRetry downloads and validates the archive at url, retrying
+// network errors and server errors with exponential backoff.
+func (f *Fetcher) downloadWithRetry(url string, cached cache.Validators) (*archive, error) {
+	for attempt := 1; ; attempt++ {
+		a, err := f.downloadZip(url, cached)
+		if err == nil {
+			if err := f.validateArchive(a.data); err != nil {
+				return nil, err
+			}
+			return a, nil
+		}
+		if errors.Is(err, errNotModified) || !retryable(err) || attempt >= f.attempts {
+			return nil, err
+		}
+		time.Sleep(f.backoff << (attempt - 1))
+	}
+}
+
+// retryable reports whether a failed download may succeed when retried.
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code == http.StatusTooManyRequests || se.code >= 500
+	}
+	return !errors.Is(err, errTooLarge)
+}
+
+// validateArchive checks that data is a ZIP archive with a data directory
+// holding at least the minimum number of lists.
+func (f *Fetcher) validateArchive(data []byte) error {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("invalid archive: %w", err)
+	}
+	prefix, ok := detectDataPrefix(reader)
+	if !ok {
+		return errors.New("invalid archive: no data directory")
+	}
+	if n := len(zipLists{reader: reader, prefix: prefix}.Names()); n < f.minLists {
+		return fmt.Errorf("invalid archive: %d lists, want at least %d", n, f.minLists)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package fetcher
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/xxxbrian/surge-geosite/internal/cache"
+	"github.com/xxxbrian/surge-geosite/internal/overlay"
+)
+
+// errPinUnsupported is returned by upstreams without snapshots to pin.
+var errPinUnsupported = errors.New("pinning is not supported by this upstream")
+
+// ErrUnknownRevision is returned for revisions that are not kept.
+var ErrUnknownRevision = errors.New("unknown revision")
+
+// Lists is a snapshot of the upstream list files.
+type Lists interface {
+	// ReadList returns the content of the list name.
+	ReadList(name string) (string, error)
+	// Names returns the sorted names of all lists.
+	Names() []string
+	// Commit returns the upstream commit the lists were archived from, or
+	// "" when it is unknown.
+	Commit() string
+}
+
+// Upstream provides snapshots of the upstream lists, either from a
+// downloaded archive (Fetcher) or from a local directory (LocalSource).
+type Upstream interface {
+	// Lists returns the current snapshot and the ETag identifying it,
+	// refreshing the snapshot when it expired.
+	Lists() (Lists, string, error)
+	// Refresh checks for a new snapshot regardless of expiry.
+	Refresh() (Lists, string, error)
+	// URL describes where the lists come from.
+	URL() string
+	// Pinned returns the ref the upstream is pinned to, or "".
+	Pinned() string
+	// Pin serves the snapshot of ref until Unpin is called.
+	Pin(ref string) error
+	// Unpin resumes following the upstream.
+	Unpin() error
+	// Rollback restores and pins the previous snapshot, returning its ETag.
+	Rollback() (string, error)
+	// Stale returns why the last check failed while an older snapshot is
+	// served, or nil.
+	Stale() error
+	// Revision returns the kept snapshot selected by rev, an ETag or a
+	// commit prefix, patched by the current overlay.
+	Revision(rev string) (Lists, string, error)
+	// Snapshots describes the kept snapshots, current first.
+	Snapshots() []cache.Snapshot
+}
+
+// overlayLists patches lists with an overlay.
+type overlayLists struct {
+	lists   Lists
+	overlay *overlay.Overlay
+}
+
+// withOverlay returns lists patched by o, or lists itself when o is nil.
+func withOverlay(lists Lists, o *overlay.Overlay) Lists {
+	if o == nil {
+		return lists
+	}
+	return overlayLists{lists: lists, overlay: o}
+}
+
+// ReadList reads a list, patched by the overlay.
+func (l overlayLists) ReadList(name string) (string, error) {
+	content, err := l.lists.ReadList(name)
+	if patched, ok := l.overlay.Apply(name, content, err == nil); ok {
+		return patched, nil
+	}
+	return "", err
+}
+
+// Names returns the sorted names of the upstream and overlay lists.
+func (l overlayLists) Names() []string {
+	seen := make(map[string]bool)
+	for _, name := range l.lists.Names() {
+		seen[name] = true
+	}
+	for _, name := range l.overlay.Names() {
+		seen[name] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Commit returns the commit of the patched lists.
+func (l overlayLists) Commit() string {
+	return l.lists.Commit()
+}
+
+// upstreamETag removes the overlay version from an ETag returned by
+// overlayETag, also when its "+" was decoded to a space in a query string.
+func upstreamETag(etag string) string {
+	for _, sep := range []string{"+overlay-", " overlay-"} {
+		etag, _, _ = strings.Cut(etag, sep)
+	}
+	return etag
+}
+
+// overlayETag appends the overlay version to an upstream ETag.
+func overlayETag(etag string, o *overlay.Overlay) string {
+	if o == nil {
+		return etag
+	}
+	if version := o.Version(); version != "" {
+		return etag + "+overlay-" + version
+	}
+	return etag
+}
@@ -9,8 +9,9 @@ import (
 )
 
 type GeoIP struct {
-	mu    sync.RWMutex
-	cidrs map[string][]string
+	mu         sync.RWMutex
+	cidrs      map[string][]string
+	generation uint64
 }
 
 func NewGeoIP() *GeoIP {
@@ -70,6 +71,7 @@ func (g *GeoIP) Load(data []byte) error {
 
 	g.mu.Lock()
 	g.cidrs = newCIDRs
+	g.generation++
 	g.mu.Unlock()
 
 	return nil
@@ -83,3 +85,12 @@ func (g *GeoIP) GetCIDRs(code string) ([]string, bool) {
 	cidrs, ok := g.cidrs[strings.ToUpper(code)]
 	return cidrs, ok
 }
+
+// Generation returns a counter incremented on every successful Load, usable
+// to invalidate results derived from the loaded data.
+func (g *GeoIP) Generation() uint64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.generation
+}
@@ -0,0 +1,112 @@
+// Package httpclient builds the HTTP clients used for outbound requests, so
+// proxy, TLS and User-Agent settings apply to every upstream alike.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// DefaultUserAgent is sent when Config.UserAgent is empty.
+const DefaultUserAgent = "Surge-Geosite-Go/1.0"
+
+// Config configures the transport shared by all outbound clients.
+type Config struct {
+	// Proxy is an http://, https:// or socks5:// proxy URL. When empty the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables apply.
+	Proxy string
+	// CAFile is a PEM bundle of certificates trusted in addition to the
+	// system roots.
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and its key.
+	CertFile string
+	KeyFile  string
+	// UserAgent replaces DefaultUserAgent.
+	UserAgent string
+}
+
+// defaultTransport is the transport of Default clients.
+var defaultTransport = &userAgentTransport{base: http.DefaultTransport, userAgent: DefaultUserAgent}
+
+// NewTransport returns a transport configured by cfg.
+func NewTransport(cfg Config) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.Proxy != "" {
+		proxy, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", proxy.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if cfg.CAFile != "" || cfg.CertFile != "" || cfg.KeyFile != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.CAFile != "" {
+			pem, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if cfg.CertFile != "" || cfg.KeyFile != "" {
+			if cfg.CertFile == "" || cfg.KeyFile == "" {
+				return nil, errors.New("client certificate and key must be given together")
+			}
+			cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	userAgent := cfg.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	return &userAgentTransport{base: transport, userAgent: userAgent}, nil
+}
+
+// New returns a client using transport with the given request timeout.
+func New(transport http.RoundTripper, timeout time.Duration) *http.Client {
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// Default returns a client with the default transport settings.
+func Default(timeout time.Duration) *http.Client {
+	return New(defaultTransport, timeout)
+}
+
+// userAgentTransport sets the User-Agent of requests that have none.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.base.RoundTrip(req)
+}
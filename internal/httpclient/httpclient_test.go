@@ -0,0 +1,49 @@
+package httpclient_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xxxbrian/surge-geosite/internal/httpclient"
+)
+
+func TestTransportProxyAndUserAgent(t *testing.T) {
+	var target, userAgent string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests through an HTTP proxy carry the absolute target URL.
+		target = r.URL.String()
+		userAgent = r.UserAgent()
+	}))
+	defer proxy.Close()
+
+	transport, err := httpclient.NewTransport(httpclient.Config{Proxy: proxy.URL, UserAgent: "test-agent/1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := httpclient.New(transport, time.Second).Get("http://upstream.invalid/data.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if target != "http://upstream.invalid/data.zip" {
+		t.Errorf("proxy got %q, want the upstream URL", target)
+	}
+	if userAgent != "test-agent/1.0" {
+		t.Errorf("User-Agent = %q, want test-agent/1.0", userAgent)
+	}
+}
+
+func TestTransportRejectsInvalidConfig(t *testing.T) {
+	for name, cfg := range map[string]httpclient.Config{
+		"scheme":  {Proxy: "ftp://proxy.example.com"},
+		"ca file": {CAFile: "/nonexistent/ca.pem"},
+		"key":     {CertFile: "client.pem"},
+	} {
+		if _, err := httpclient.NewTransport(cfg); err == nil {
+			t.Errorf("%s: NewTransport succeeded, want error", name)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Package ipset merges CIDR lists into sorted address ranges, the form used by
+// binary rule-set encodings and range lookups.
+package ipset
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Range is an inclusive range of addresses of a single family.
+type Range struct {
+	From netip.Addr
+	To   netip.Addr
+}
+
+// Ranges parses CIDRs (or bare addresses) and returns the merged ranges they
+// cover, IPv4 ranges first, each family in ascending order.
+func Ranges(cidrs []string) ([]Range, error) {
+	ranges := make([]Range, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if addr := prefix.Addr(); addr.Is4In6() {
+			bits := prefix.Bits() - 96
+			if bits < 0 {
+				bits = 0
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), bits)
+		}
+		prefix = prefix.Masked()
+		ranges = append(ranges, Range{From: prefix.Addr(), To: lastAddr(prefix)})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].From.Less(ranges[j].From)
+	})
+
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && merged[n-1].To.BitLen() == r.From.BitLen() {
+			last := &merged[n-1]
+			// Adjacent or overlapping ranges collapse into one.
+			if next := last.To.Next(); !next.IsValid() || !next.Less(r.From) {
+				if last.To.Less(r.To) {
+					last.To = r.To
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged, nil
+}
+
+// lastAddr returns the highest address within prefix.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr()
+	bytes := addr.AsSlice()
+	for i := prefix.Bits(); i < len(bytes)*8; i++ {
+		bytes[i/8] |= 1 << uint(7-i%8)
+	}
+	last, _ := netip.AddrFromSlice(bytes)
+	return last
+}
@@ -0,0 +1,44 @@
+package ipset_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/xxxbrian/surge-geosite/internal/ipset"
+)
+
+func TestRanges(t *testing.T) {
+	ranges, err := ipset.Ranges([]string{
+		"2001:db8::/32",
+		"10.0.0.0/8",
+		"1.0.1.0/24",
+		"1.0.0.0/24",
+		"10.1.0.0/16",
+		"8.8.8.8",
+		"::ffff:9.9.9.0/120",
+	})
+	if err != nil {
+		t.Fatalf("Ranges failed: %v", err)
+	}
+
+	var got []string
+	for _, r := range ranges {
+		got = append(got, r.From.String()+"-"+r.To.String())
+	}
+	want := []string{
+		"1.0.0.0-1.0.1.255",
+		"8.8.8.8-8.8.8.8",
+		"9.9.9.0-9.9.9.255",
+		"10.0.0.0-10.255.255.255",
+		"2001:db8::-2001:db8:ffff:ffff:ffff:ffff:ffff:ffff",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Ranges() = %v, want %v", got, want)
+	}
+}
+
+func TestRangesInvalid(t *testing.T) {
+	if _, err := ipset.Ranges([]string{"not-a-cidr"}); err == nil {
+		t.Error("Ranges succeeded, want error")
+	}
+}
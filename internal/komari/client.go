@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/xxxbrian/surge-geosite/internal/httpclient"
 )
 
 const (
@@ -36,14 +38,17 @@ func NewClient(apiKey string, baseURL string) *Client {
 	}
 
 	return &Client{
-		apiKey:  apiKey,
-		baseURL: baseURL,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		apiKey:     apiKey,
+		baseURL:    baseURL,
+		httpClient: httpclient.Default(30 * time.Second),
 	}
 }
 
+// SetHTTPClient 设置请求 Komari API 使用的 HTTP 客户端
+func (c *Client) SetHTTPClient(client *http.Client) {
+	c.httpClient = client
+}
+
 // doRequest 执行 HTTP 请求
 func (c *Client) doRequest(url string) ([]byte, error) {
 	req, err := http.NewRequest("GET", url, nil)
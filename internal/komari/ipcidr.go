@@ -1,10 +1,7 @@
 // Package komari 提供 Komari API 客户端和 IP CIDR 规则生成器。
 package komari
 
-import (
-	"fmt"
-	"strings"
-)
+import "strings"
 
 // 延迟阈值常量（毫秒）
 const (
@@ -135,57 +132,3 @@ func normalizeIPv6(ipv6 string) string {
 	}
 	return ipv6
 }
-
-// RenderSurge 渲染 Surge 格式规则
-func RenderSurge(cidrs []IPCIDR) string {
-	var builder strings.Builder
-	for _, cidr := range cidrs {
-		if cidr.IsIPv6 {
-			builder.WriteString(fmt.Sprintf("IP-CIDR6,%s\n", cidr.IP))
-		} else {
-			builder.WriteString(fmt.Sprintf("IP-CIDR,%s\n", cidr.IP))
-		}
-	}
-	return strings.TrimRight(builder.String(), "\n")
-}
-
-// RenderMihomo 渲染 Mihomo 格式规则
-// Mihomo 目前兼容 Surge 格式
-func RenderMihomo(cidrs []IPCIDR) string {
-	return RenderSurge(cidrs)
-}
-
-// RenderEgern 渲染为 Egern YAML 格式
-func RenderEgern(cidrs []IPCIDR) string {
-	var ipv4List, ipv6List []string
-
-	for _, cidr := range cidrs {
-		if cidr.IsIPv6 {
-			ipv6List = append(ipv6List, cidr.IP)
-		} else {
-			ipv4List = append(ipv4List, cidr.IP)
-		}
-	}
-
-	var b strings.Builder
-
-	if len(ipv4List) > 0 {
-		b.WriteString("ip_cidr_set:\n")
-		for _, ip := range ipv4List {
-			b.WriteString("  - ")
-			b.WriteString(ip)
-			b.WriteString("\n")
-		}
-	}
-
-	if len(ipv6List) > 0 {
-		b.WriteString("ip_cidr6_set:\n")
-		for _, ip := range ipv6List {
-			b.WriteString("  - ")
-			b.WriteString(ip)
-			b.WriteString("\n")
-		}
-	}
-
-	return strings.TrimRight(b.String(), "\n")
-}
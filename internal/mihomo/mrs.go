@@ -0,0 +1,105 @@
+// Package mihomo encodes mihomo binary rule-providers (.mrs).
+package mihomo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strings"
+
+	"github.com/xxxbrian/surge-geosite/internal/ipset"
+	"github.com/xxxbrian/surge-geosite/internal/succinct"
+)
+
+var magicBytes = [4]byte{'M', 'R', 'S', 1}
+
+// Rule-provider behaviors as encoded in the .mrs header.
+const (
+	behaviorDomain byte = 0
+	behaviorIPCIDR byte = 1
+)
+
+// MarshalDomainSet encodes a domain behavior payload ("example.com",
+// "+.example.com", "*.example.com") as a .mrs rule-provider.
+func MarshalDomainSet(domains []string) ([]byte, error) {
+	keys := make([]string, 0, len(domains)*2)
+	seen := make(map[string]bool, cap(keys))
+	add := func(domain string) {
+		key := reverse(domain)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, domain := range domains {
+		// mihomo stores "+.example.com" as both the domain itself and a
+		// "+" label matching every subdomain.
+		if base, ok := strings.CutPrefix(domain, "+."); ok {
+			add(base)
+		}
+		add(domain)
+	}
+	sort.Strings(keys)
+
+	set := succinct.Build(keys)
+	var body bytes.Buffer
+	// Domain set version.
+	body.WriteByte(1)
+	writeUint64s(&body, set.Leaves)
+	writeUint64s(&body, set.LabelBitmap)
+	binary.Write(&body, binary.BigEndian, int64(len(set.Labels)))
+	body.Write(set.Labels)
+
+	return marshal(behaviorDomain, len(domains), body.Bytes()), nil
+}
+
+// MarshalIPCIDRSet encodes an ipcidr behavior payload as a .mrs rule-provider.
+func MarshalIPCIDRSet(cidrs []string) ([]byte, error) {
+	ranges, err := ipset.Ranges(cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	// IP CIDR set version.
+	body.WriteByte(1)
+	binary.Write(&body, binary.BigEndian, int64(len(ranges)))
+	for _, r := range ranges {
+		from := r.From.As16()
+		to := r.To.As16()
+		body.Write(from[:])
+		body.Write(to[:])
+	}
+
+	return marshal(behaviorIPCIDR, len(cidrs), body.Bytes()), nil
+}
+
+func marshal(behavior byte, count int, body []byte) []byte {
+	var content bytes.Buffer
+	content.Write(magicBytes[:])
+	content.WriteByte(behavior)
+	binary.Write(&content, binary.BigEndian, int64(count))
+	// Length of the reserved extra section.
+	binary.Write(&content, binary.BigEndian, int64(0))
+	content.Write(body)
+
+	var out bytes.Buffer
+	writeZstdStored(&out, content.Bytes())
+	return out.Bytes()
+}
+
+func writeUint64s(buf *bytes.Buffer, values []uint64) {
+	binary.Write(buf, binary.BigEndian, int64(len(values)))
+	for _, value := range values {
+		binary.Write(buf, binary.BigEndian, value)
+	}
+}
+
+// reverse reverses a domain rune by rune, the key order used by mihomo's trie.
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
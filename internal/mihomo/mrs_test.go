@@ -0,0 +1,106 @@
+package mihomo_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/xxxbrian/surge-geosite/internal/mihomo"
+)
+
+func TestMarshalDomainSet(t *testing.T) {
+	got, err := mihomo.MarshalDomainSet([]string{"+.cn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{
+		0x28, 0xb5, 0x2f, 0xfd, // zstd magic
+		0x00,             // frame header descriptor
+		0x38,             // 128 KiB window
+		0x11, 0x02, 0x00, // last raw block of 66 bytes
+		'M', 'R', 'S', 1, // magic and version
+		0x00,                   // domain behavior
+		0, 0, 0, 0, 0, 0, 0, 1, // rule count
+		0, 0, 0, 0, 0, 0, 0, 0, // extra section length
+		0x01,                   // domain set version
+		0, 0, 0, 0, 0, 0, 0, 1, // leaves length
+		0, 0, 0, 0, 0, 0, 0, 0x14, // leaves: "nc", "nc.+"
+		0, 0, 0, 0, 0, 0, 0, 1, // label bitmap length
+		0, 0, 0, 0, 0, 0, 0x01, 0xaa, // label bitmap
+		0, 0, 0, 0, 0, 0, 0, 4, // labels length
+		'n', 'c', '.', '+', // labels
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("MarshalDomainSet() = %x, want %x", got, want)
+	}
+}
+
+func TestMarshalIPCIDRSet(t *testing.T) {
+	got, err := mihomo.MarshalIPCIDRSet([]string{"10.0.0.0/8", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{'M', 'R', 'S', 1, 0x01}
+	want = binary.BigEndian.AppendUint64(want, 2) // rule count
+	want = binary.BigEndian.AppendUint64(want, 0) // extra section length
+	want = append(want, 0x01)                     // ip cidr set version
+	want = binary.BigEndian.AppendUint64(want, 2) // range count
+	want = append(want, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 10, 0, 0, 0)
+	want = append(want, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 10, 255, 255, 255)
+	want = append(want, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	want = append(want, 0x20, 0x01, 0x0d, 0xb8, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	if content := zstdContent(t, got); !bytes.Equal(content, want) {
+		t.Errorf("MarshalIPCIDRSet() content = %x, want %x", content, want)
+	}
+}
+
+func TestZstdBlocks(t *testing.T) {
+	// 5000 separate ranges of 32 bytes need two 128 KiB raw blocks.
+	cidrs := make([]string, 5000)
+	for i := range cidrs {
+		cidrs[i] = fmt.Sprintf("10.%d.%d.0/32", i/256, i%256)
+	}
+	got, err := mihomo.MarshalIPCIDRSet(cidrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := zstdContent(t, got)
+	if n := 4 + 1 + 8 + 8 + 1 + 8 + 5000*32; len(content) != n {
+		t.Errorf("content is %d bytes, want %d", len(content), n)
+	}
+	if first := got[6:9]; !bytes.Equal(first, []byte{0x00, 0x00, 0x10}) {
+		t.Errorf("first block header = %x, want a full raw block that is not the last", first)
+	}
+}
+
+// zstdContent checks that data is a zstd frame of raw blocks and returns
+// their content.
+func zstdContent(t *testing.T, data []byte) []byte {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x38}) {
+		t.Fatalf("frame header = %x", data[:min(len(data), 6)])
+	}
+	data = data[6:]
+	var content []byte
+	for {
+		if len(data) < 3 {
+			t.Fatal("truncated zstd frame")
+		}
+		header := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16
+		last, blockType, size := header&1, header>>1&3, int(header>>3)
+		if blockType != 0 || size > 128<<10 || len(data) < 3+size {
+			t.Fatalf("invalid block header %x", data[:3])
+		}
+		content = append(content, data[3:3+size]...)
+		data = data[3+size:]
+		if last == 1 {
+			if len(data) != 0 {
+				t.Fatalf("%d bytes after the last block", len(data))
+			}
+			return content
+		}
+	}
+}
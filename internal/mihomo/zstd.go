@@ -0,0 +1,46 @@
+package mihomo
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	zstdMagic = 0xFD2FB528
+	// zstdMaxBlockSize is the largest block a zstd frame may carry.
+	zstdMaxBlockSize = 128 << 10
+	// zstdWindowDescriptor declares a 128 KiB window, enough for raw blocks
+	// of the maximum size (exponent 7, mantissa 0).
+	zstdWindowDescriptor = 7 << 3
+	zstdBlockTypeRaw     = 0
+)
+
+// writeZstdStored wraps data in a zstd frame made of raw (stored) blocks.
+// mihomo only requires a valid zstd stream, and rule-providers are small
+// enough that compression is not worth an external dependency.
+func writeZstdStored(buf *bytes.Buffer, data []byte) {
+	var header [4]byte
+	binary.LittleEndian.PutUint32(header[:], zstdMagic)
+	buf.Write(header[:])
+	// Frame header descriptor: no content size, no checksum, no dictionary.
+	buf.WriteByte(0)
+	buf.WriteByte(zstdWindowDescriptor)
+
+	for {
+		size := len(data)
+		if size > zstdMaxBlockSize {
+			size = zstdMaxBlockSize
+		}
+		last := 0
+		if size == len(data) {
+			last = 1
+		}
+		blockHeader := uint32(last) | zstdBlockTypeRaw<<1 | uint32(size)<<3
+		buf.Write([]byte{byte(blockHeader), byte(blockHeader >> 8), byte(blockHeader >> 16)})
+		buf.Write(data[:size])
+		data = data[size:]
+		if last == 1 {
+			return
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Package overlay serves local v2fly-format lists that add to or patch the
+// upstream domain-list-community data.
+package overlay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rulePrefixes are the v2fly line prefixes; lines without one are domain rules.
+var rulePrefixes = []string{"domain:", "full:", "keyword:", "regexp:", "include:"}
+
+// list is one overlay file.
+type list struct {
+	// lines are appended to the upstream list of the same name.
+	lines []string
+	// remove holds the rules dropped from the upstream list, written as
+	// "!domain:example.com" in the overlay file.
+	remove map[string]bool
+}
+
+// Overlay holds the lists of an overlay directory. Files are named after the
+// list they add or patch, like upstream data files.
+type Overlay struct {
+	dir     string
+	mu      sync.RWMutex
+	lists   map[string]*list
+	stamp   string
+	version string
+}
+
+// New loads the overlay lists in dir.
+func New(dir string) (*Overlay, error) {
+	o := &Overlay{dir: dir, lists: make(map[string]*list)}
+	if _, err := o.Reload(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Reload re-reads the directory if any file was added, removed or modified
+// since the last load, reporting whether the lists changed.
+func (o *Overlay) Reload() (bool, error) {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return false, err
+	}
+
+	var files []string
+	var stamp strings.Builder
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return false, err
+		}
+		files = append(files, entry.Name())
+		fmt.Fprintf(&stamp, "%s %d %d\n", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+
+	o.mu.RLock()
+	unchanged := o.stamp == stamp.String()
+	o.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	lists := make(map[string]*list, len(files))
+	hash := sha256.New()
+	for _, file := range files {
+		content, err := os.ReadFile(filepath.Join(o.dir, file))
+		if err != nil {
+			return false, err
+		}
+		name := strings.ToLower(file)
+		lists[name] = parseList(string(content))
+		hash.Write([]byte(name + "\n"))
+		hash.Write(content)
+		hash.Write([]byte{0})
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.lists = lists
+	o.stamp = stamp.String()
+	o.version = ""
+	if len(lists) > 0 {
+		o.version = hex.EncodeToString(hash.Sum(nil))[:12]
+	}
+	return true, nil
+}
+
+// Watch reloads the directory every interval and calls onChange after the
+// lists changed. It never returns.
+func (o *Overlay) Watch(interval time.Duration, onChange func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		changed, err := o.Reload()
+		if err != nil {
+			log.Printf("Overlay reload failed: %v", err)
+			continue
+		}
+		if changed {
+			log.Printf("Overlay reloaded from %s (version %s)", o.dir, o.Version())
+			if onChange != nil {
+				onChange()
+			}
+		}
+	}
+}
+
+// Version identifies the current overlay contents; it is empty when the
+// directory holds no lists.
+func (o *Overlay) Version() string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.version
+}
+
+// Names returns the sorted names of the overlay lists.
+func (o *Overlay) Names() []string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	names := make([]string, 0, len(o.lists))
+	for name := range o.lists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Apply patches the upstream content of list name, where found reports
+// whether upstream has the list. Removed rules are dropped from upstream and
+// the overlay lines appended; a list only in the overlay is served as is.
+// It reports false when neither has the list.
+func (o *Overlay) Apply(name, upstream string, found bool) (string, bool) {
+	o.mu.RLock()
+	l, ok := o.lists[name]
+	o.mu.RUnlock()
+	if !ok {
+		return upstream, found
+	}
+
+	var lines []string
+	if found {
+		for _, line := range strings.Split(upstream, "\n") {
+			if len(l.remove) > 0 && l.remove[ruleKey(line)] {
+				continue
+			}
+			lines = append(lines, line)
+		}
+	}
+	lines = append(lines, l.lines...)
+	return strings.Join(lines, "\n"), true
+}
+
+func parseList(content string) *list {
+	l := &list{remove: make(map[string]bool)}
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if removed, ok := strings.CutPrefix(trimmed, "!"); ok {
+			if key := ruleKey(removed); key != "" {
+				l.remove[key] = true
+			}
+			continue
+		}
+		l.lines = append(l.lines, line)
+	}
+	return l
+}
+
+// ruleKey returns the rule of a list line without attributes or comments,
+// spelling bare domains as "domain:" rules. Comments and blank lines have no key.
+func ruleKey(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return ""
+	}
+	for _, prefix := range rulePrefixes {
+		if strings.HasPrefix(fields[0], prefix) {
+			return fields[0]
+		}
+	}
+	return "domain:" + fields[0]
+}
@@ -0,0 +1,64 @@
+package overlay_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xxxbrian/surge-geosite/internal/overlay"
+)
+
+func TestOverlay(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("google", "!domain:google.cn\n!full:www.google.com\nfull:internal.google.com\n")
+	write("corp", "domain:corp.example\ninclude:google\n")
+
+	o, err := overlay.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := o.Names(); len(got) != 2 || got[0] != "corp" || got[1] != "google" {
+		t.Errorf("Names() = %v, want [corp google]", got)
+	}
+	version := o.Version()
+	if version == "" {
+		t.Error("Version() is empty")
+	}
+
+	upstream := "google.com\ngoogle.cn @cn\nfull:www.google.com # homepage\nkeyword:google"
+	got, ok := o.Apply("google", upstream, true)
+	if want := "google.com\nkeyword:google\nfull:internal.google.com\n"; !ok || got != want {
+		t.Errorf("Apply(google) = %q, %v, want %q", got, ok, want)
+	}
+	if got, ok := o.Apply("corp", "", false); !ok || got != "domain:corp.example\ninclude:google\n" {
+		t.Errorf("Apply(corp) = %q, %v", got, ok)
+	}
+	if got, ok := o.Apply("apple", "apple.com", true); !ok || got != "apple.com" {
+		t.Errorf("Apply(apple) = %q, %v, want upstream", got, ok)
+	}
+	if _, ok := o.Apply("missing", "", false); ok {
+		t.Error("Apply(missing) found a list")
+	}
+
+	if changed, err := o.Reload(); err != nil || changed {
+		t.Errorf("Reload() without changes = %v, %v", changed, err)
+	}
+	write("corp", "domain:corp.example\n")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "corp"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := o.Reload(); err != nil || !changed {
+		t.Errorf("Reload() after change = %v, %v", changed, err)
+	}
+	if o.Version() == version {
+		t.Error("Version() unchanged after reload")
+	}
+}
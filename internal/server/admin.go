@@ -0,0 +1,148 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/xxxbrian/surge-geosite/internal/fetcher"
+)
+
+// pinnedHeader reports the ref an upstream is pinned to.
+const pinnedHeader = "X-Geosite-Pinned"
+
+// staleWarning marks responses built from a snapshot served because
+// upstream could not be checked.
+const staleWarning = `111 - "Revalidation Failed"`
+
+// setUpstreamHeaders sets the pinned header when f is pinned and a Warning
+// header when f serves a stale snapshot.
+func setUpstreamHeaders(w http.ResponseWriter, f fetcher.Upstream) {
+	if ref := f.Pinned(); ref != "" {
+		w.Header().Set(pinnedHeader, ref)
+	}
+	if f.Stale() != nil {
+		w.Header().Set("Warning", staleWarning)
+	}
+}
+
+// requireAdmin rejects requests without the admin bearer token.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleAdminPin handles /admin/pin: GET lists the pins of all sources,
+// POST ?ref=<tag|commit|url> pins a source and DELETE unpins it. The source
+// is selected with ?source=name and defaults to the default upstream.
+func (s *Server) handleAdminPin(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		pins := make(map[string]string, len(s.sources))
+		for name, src := range s.sources {
+			if name == "" {
+				name = "default"
+			}
+			pins[name] = src.upstream.Pinned()
+		}
+		writeJSON(w, pins)
+		return
+	}
+
+	sourceName, src, ok := s.adminSource(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		ref := strings.TrimSpace(r.URL.Query().Get("ref"))
+		if err := src.upstream.Pin(ref); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to pin: %v", err), http.StatusBadGateway)
+			return
+		}
+		log.Printf("Source %q pinned to %s", sourceName, ref)
+	case http.MethodDelete:
+		if err := src.upstream.Unpin(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to refresh after unpinning: %v", err), http.StatusBadGateway)
+			return
+		}
+		log.Printf("Source %q unpinned", sourceName)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.afterSnapshotChange(sourceName)
+	writeJSON(w, map[string]string{"pinned": src.upstream.Pinned()})
+}
+
+// handleAdminRollback handles POST /admin/rollback, restoring the previous
+// snapshot of a source and pinning it there.
+func (s *Server) handleAdminRollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sourceName, src, ok := s.adminSource(w, r)
+	if !ok {
+		return
+	}
+	etag, err := src.upstream.Rollback()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to roll back: %v", err), http.StatusConflict)
+		return
+	}
+	log.Printf("Source %q rolled back to ETag %s", sourceName, truncateETag(etag))
+	s.afterSnapshotChange(sourceName)
+	writeJSON(w, map[string]string{"etag": etag, "pinned": src.upstream.Pinned()})
+}
+
+// adminSource returns the source selected by an admin request.
+func (s *Server) adminSource(w http.ResponseWriter, r *http.Request) (string, *upstream, bool) {
+	name := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("source")))
+	src, ok := s.lookupSource(name)
+	if !ok {
+		names := make([]string, 0, len(s.sources))
+		for configured := range s.sources {
+			if configured != "" {
+				names = append(names, configured)
+			}
+		}
+		sort.Strings(names)
+		http.Error(w, "Unknown source: "+name+"; configured: "+strings.Join(names, ", "), http.StatusNotFound)
+		return "", nil, false
+	}
+	return name, src, true
+}
+
+// afterSnapshotChange regenerates the index when the default upstream
+// switched snapshots.
+func (s *Server) afterSnapshotChange(sourceName string) {
+	if sourceName != "" {
+		return
+	}
+	if err := s.RefreshIndex(); err != nil {
+		log.Printf("Index refresh failed: %v", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,70 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xxxbrian/surge-geosite/internal/cache"
+	"github.com/xxxbrian/surge-geosite/internal/fetcher"
+	"github.com/xxxbrian/surge-geosite/internal/server"
+)
+
+func TestAdminToken(t *testing.T) {
+	_, handler := newTestServer(t, server.Config{}, map[string]string{"google": "google.com\n"})
+	if rec := get(handler, "/admin/pin", map[string]string{"Authorization": "Bearer "}); rec.Code != http.StatusNotFound {
+		t.Errorf("admin endpoint without a configured token: status %d, want 404", rec.Code)
+	}
+
+	_, handler = newTestServer(t, server.Config{AdminToken: "secret"}, map[string]string{"google": "google.com\n"})
+	for _, auth := range []string{"", "Bearer", "Bearer wrong", "Bearer secre", "Basic secret", "secret"} {
+		if rec := get(handler, "/admin/pin", map[string]string{"Authorization": auth}); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status %d, want 401", auth, rec.Code)
+		}
+	}
+	rec := get(handler, "/admin/pin", map[string]string{"Authorization": "Bearer secret"})
+	if rec.Code != http.StatusOK || rec.Body.String() != "{\n  \"default\": \"\"\n}" {
+		t.Errorf("GET /admin/pin: %d %q", rec.Code, rec.Body)
+	}
+
+	// Local sources cannot be pinned or rolled back.
+	for _, target := range []string{"/admin/pin?ref=v1", "/admin/rollback"} {
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code == http.StatusOK || rec.Code == http.StatusUnauthorized {
+			t.Errorf("POST %s: status %d, want an error", target, rec.Code)
+		}
+	}
+}
+
+func TestIndexPinned(t *testing.T) {
+	for _, cfg := range []server.Config{{}, {BaseURL: "https://geo.example"}} {
+		up := pinnedSource{newLocalSource(t, map[string]string{"google": "google.com\n"})}
+		srv := server.NewServer(up, fetcher.NewGeoIPFetcher(""), cache.NewResultCache(time.Hour), cfg)
+		if err := srv.RefreshIndex(); err != nil {
+			t.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		srv.SetupRoutes(mux)
+
+		var index map[string]string
+		if err := json.Unmarshal(get(mux, "/geosite", nil).Body.Bytes(), &index); err != nil {
+			t.Fatal(err)
+		}
+		if index["pinned"] != "v1" || index["google"] == "" {
+			t.Errorf("base URL %q: index = %v, want google and pinned v1", cfg.BaseURL, index)
+		}
+
+		index = nil
+		if err := json.Unmarshal(get(mux, "/v/"+snapshotETag(t, mux, "")+"/geosite", nil).Body.Bytes(), &index); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := index["pinned"]; ok {
+			t.Errorf("base URL %q: revision index = %v, want no pinned entry", cfg.BaseURL, index)
+		}
+	}
+}
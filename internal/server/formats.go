@@ -0,0 +1,123 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xxxbrian/surge-geosite/internal/converter"
+)
+
+// vendorMediaTypePrefix selects a format by name in an Accept header, e.g.
+// "application/vnd.surge-geosite.sing-box-srs".
+const vendorMediaTypePrefix = "application/vnd.surge-geosite."
+
+// ruleKind is the kind of rules an endpoint serves.
+type ruleKind int
+
+const (
+	domainRules ruleKind = iota
+	ipRules
+	// mixedRules endpoints serve rule lists holding both kinds.
+	mixedRules
+)
+
+// supports reports whether renderer r can serve an endpoint of kind.
+func supports(r converter.Renderer, kind ruleKind) bool {
+	switch kind {
+	case domainRules:
+		return converter.SupportsDomains(r)
+	case ipRules:
+		return converter.SupportsIPs(r)
+	}
+	return true
+}
+
+// lookupFormat returns the renderer registered under name if it can serve
+// an endpoint of kind.
+func lookupFormat(name string, kind ruleKind) (converter.Renderer, bool) {
+	r, ok := converter.LookupRenderer(name)
+	if !ok || !supports(r, kind) {
+		return nil, false
+	}
+	return r, true
+}
+
+// supportedFormats returns the sorted names of the formats supporting kind.
+func supportedFormats(kind ruleKind) []string {
+	var names []string
+	for _, r := range converter.Renderers() {
+		if supports(r, kind) {
+			names = append(names, r.Name())
+		}
+	}
+	return names
+}
+
+// negotiateFormat selects the output format of a request. The "format" query
+// parameter takes precedence, then a vendor media type in the Accept header;
+// otherwise the format implied by the path is used, whatever else Accept
+// lists, so existing clients keep getting the format they asked for. It
+// writes a 406 response listing the supported formats and returns nil when
+// the requested format is not available.
+func negotiateFormat(w http.ResponseWriter, r *http.Request, kind ruleKind, pathFormat string) converter.Renderer {
+	w.Header().Add("Vary", "Accept")
+	name := pathFormat
+	if requested := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format"))); requested != "" {
+		name = requested
+	} else if vendor, ok := acceptFormat(r.Header.Get("Accept"), kind); ok {
+		name = vendor
+	}
+
+	f, ok := lookupFormat(name, kind)
+	if !ok {
+		http.Error(w, "Unsupported format: "+name+"; supported: "+strings.Join(supportedFormats(kind), ", "), http.StatusNotAcceptable)
+		return nil
+	}
+	return f
+}
+
+// acceptFormat returns the most preferred format of kind named by a vendor
+// media type in an Accept header. Other media types are ignored. When the
+// vendor media types only name formats that are unknown or cannot serve
+// kind, the most preferred of them is returned, so that the request is
+// rejected like an unsupported "format" query parameter.
+func acceptFormat(accept string, kind ruleKind) (string, bool) {
+	type mediaRange struct {
+		name string
+		q    float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		name, ok := strings.CutPrefix(mediaType, vendorMediaTypePrefix)
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{name: name, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, mr := range ranges {
+		if _, ok := lookupFormat(mr.name, kind); ok {
+			return mr.name, true
+		}
+	}
+	if len(ranges) > 0 {
+		return ranges[0].name, true
+	}
+	return "", false
+}
@@ -0,0 +1,76 @@
+package server_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/xxxbrian/surge-geosite/internal/server"
+)
+
+func TestFormatNegotiation(t *testing.T) {
+	_, handler := newTestServer(t, server.Config{}, map[string]string{"google": "google.com\n"})
+
+	tests := []struct {
+		path, accept string
+		status       int
+		contentType  string
+	}{
+		{"/geosite/surge/google", "", http.StatusOK, "text/plain"},
+		{"/geosite/surge/google", "*/*", http.StatusOK, "text/plain"},
+		// Ordinary media types never override the path format.
+		{"/geosite/surge/google", "application/json", http.StatusOK, "text/plain"},
+		{"/geosite/surge/google", "application/octet-stream", http.StatusOK, "text/plain"},
+		{"/geosite/egern/google", "text/plain", http.StatusOK, "text/yaml"},
+		{"/geosite/sing-box/google", "text/html,application/xhtml+xml;q=0.9", http.StatusOK, "application/json"},
+		// Vendor media types and ?format= select any format.
+		{"/geosite/surge/google", "application/vnd.surge-geosite.sing-box", http.StatusOK, "application/json"},
+		{"/geosite/surge/google", "application/vnd.surge-geosite.egern;q=0.5, application/vnd.surge-geosite.sing-box-srs", http.StatusOK, "application/octet-stream"},
+		{"/geosite/surge/google?format=egern", "", http.StatusOK, "text/yaml"},
+		{"/geosite/surge/google?format=list", "", http.StatusNotAcceptable, ""},
+		// Vendor media types naming only unsupported formats are rejected.
+		{"/geosite/surge/google", "application/vnd.surge-geosite.unknown", http.StatusNotAcceptable, ""},
+		{"/geosite/surge/google", "text/plain, application/vnd.surge-geosite.unknown;q=0.5", http.StatusNotAcceptable, ""},
+		{"/geosite/surge/google", "application/vnd.surge-geosite.list", http.StatusNotAcceptable, ""},
+		{"/geosite/surge/google", "application/vnd.surge-geosite.unknown, application/vnd.surge-geosite.egern;q=0.1", http.StatusOK, "text/yaml"},
+		{"/geosite/surge/google", "application/vnd.surge-geosite.egern;q=0", http.StatusOK, "text/plain"},
+	}
+	for _, tt := range tests {
+		rec := get(handler, tt.path, map[string]string{"Accept": tt.accept})
+		if rec.Code != tt.status {
+			t.Errorf("%s (Accept %q): status %d, want %d: %s", tt.path, tt.accept, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.contentType) {
+			t.Errorf("%s (Accept %q): Content-Type %q, want %s", tt.path, tt.accept, got, tt.contentType)
+		}
+	}
+}
+
+func TestPolicyValidation(t *testing.T) {
+	_, handler := newTestServer(t, server.Config{}, map[string]string{"google": "google.com\n"})
+
+	rec := get(handler, "/geosite/quantumultx/google?policy=Proxy", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "host-suffix,google.com,Proxy" {
+		t.Errorf("policy=Proxy: %d %q", rec.Code, rec.Body)
+	}
+	for _, policy := range []string{"Proxy%0AHOST,evil.com,DIRECT", "Pro%0Dxy", "Proxy,DIRECT"} {
+		if rec := get(handler, "/geosite/quantumultx/google?policy="+policy, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("policy=%s: status %d, want 400", policy, rec.Code)
+		}
+	}
+}
+
+func TestDNSOptionsValidation(t *testing.T) {
+	_, handler := newTestServer(t, server.Config{}, map[string]string{"google": "google.com\n"})
+
+	rec := get(handler, "/geosite/dnsmasq/google?upstream=127.0.0.1%235353", nil)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "server=/google.com/127.0.0.1#5353") {
+		t.Errorf("upstream=127.0.0.1#5353: %d %q", rec.Code, rec.Body)
+	}
+	for _, query := range []string{"upstream=1.1.1.1%0Aaddress=/x/", "upstream=1.1.1.1%20x", "upstream=%221.1.1.1", "sinkhole=0.0%0A.0.0"} {
+		if rec := get(handler, "/geosite/dnsmasq/google?"+query, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/xxxbrian/surge-geosite/internal/converter"
+	"github.com/xxxbrian/surge-geosite/internal/fetcher"
+)
+
+// revisionLists returns the lists of src selected by the rev query
+// parameter, or its current lists, together with their ETag. The returned
+// rev is the ETag again when a past revision was selected and "" otherwise,
+// so that cache keys do not depend on how the revision was spelled.
+func revisionLists(src *upstream, r *http.Request) (fetcher.Lists, string, string, error) {
+	rev := strings.TrimSpace(r.URL.Query().Get("rev"))
+	if rev == "" {
+		lists, etag, err := src.upstream.Lists()
+		return lists, etag, "", err
+	}
+	lists, etag, err := src.upstream.Revision(rev)
+	return lists, etag, etag, err
+}
+
+// setRevisionHeaders sets the upstream headers of src for responses of its
+// current lists. A past revision is neither pinned nor stale.
+func setRevisionHeaders(w http.ResponseWriter, src *upstream, rev string) {
+	if rev == "" {
+		setUpstreamHeaders(w, src.upstream)
+	}
+}
+
+// writeListsError reports a failure of revisionLists.
+func writeListsError(w http.ResponseWriter, err error) {
+	if errors.Is(err, fetcher.ErrUnknownRevision) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, fmt.Sprintf("Failed to fetch upstream: %v", err), http.StatusInternalServerError)
+}
+
+// setIncludeCache shares the include cache of src with conv unless a past
+// revision is converted, which would evict the entries of the current one.
+func setIncludeCache(conv *converter.Converter, src *upstream, etag, rev string) {
+	if rev == "" {
+		conv.SetIncludeCache(src.includeCache, etag)
+	}
+}
+
+// revisionPrefix returns the "/v/<rev>" path prefix selecting the revision
+// of a request, or "".
+func revisionPrefix(r *http.Request) string {
+	return revisionPath(strings.TrimSpace(r.URL.Query().Get("rev")))
+}
+
+// revisionPath returns the "/v/<rev>" path prefix selecting rev, or "".
+func revisionPath(rev string) string {
+	if rev == "" {
+		return ""
+	}
+	return "/v/" + url.PathEscape(rev)
+}
+
+// handleRevision serves /v/<rev>/<path> as <path>?rev=<rev> for the geosite,
+// geosite.dat and PAC endpoints.
+func (s *Server) handleRevision(mux *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rev, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v/"), "/")
+		path := "/" + rest
+		if rev == "" || !(path == "/geosite" || strings.HasPrefix(path, "/geosite/") || path == "/dat/geosite.dat" || path == "/pac") {
+			http.NotFound(w, r)
+			return
+		}
+
+		r = r.Clone(r.Context())
+		r.URL.Path = path
+		r.URL.RawPath = ""
+		query := r.URL.Query()
+		query.Set("rev", rev)
+		r.URL.RawQuery = query.Encode()
+		mux.ServeHTTP(w, r)
+	}
+}
+
+// handleSnapshots handles /snapshots, listing the snapshots kept for the
+// source selected with ?source=name, current first.
+func (s *Server) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("source")))
+	src, ok := s.lookupSource(name)
+	if !ok {
+		http.Error(w, "Unknown source: "+name, http.StatusNotFound)
+		return
+	}
+	setUpstreamHeaders(w, src.upstream)
+	writeJSON(w, src.upstream.Snapshots())
+}
@@ -0,0 +1,89 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/xxxbrian/surge-geosite/internal/cache"
+	"github.com/xxxbrian/surge-geosite/internal/fetcher"
+	"github.com/xxxbrian/surge-geosite/internal/server"
+)
+
+// pinnedSource is a local source reporting a pinned, stale snapshot.
+type pinnedSource struct {
+	*fetcher.LocalSource
+}
+
+func (pinnedSource) Pinned() string { return "v1" }
+func (pinnedSource) Stale() error   { return errors.New("upstream down") }
+
+func TestRevisionHeaders(t *testing.T) {
+	up := pinnedSource{newLocalSource(t, map[string]string{"google": "google.com\n"})}
+	srv := server.NewServer(up, fetcher.NewGeoIPFetcher(""), cache.NewResultCache(time.Hour), server.Config{})
+	mux := http.NewServeMux()
+	srv.SetupRoutes(mux)
+
+	rec := get(mux, "/geosite/google", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Geosite-Pinned") != "v1" || rec.Header().Get("Warning") == "" {
+		t.Fatalf("current: %d, headers %v", rec.Code, rec.Header())
+	}
+
+	rec = get(mux, "/v/"+snapshotETag(t, mux, "")+"/geosite/google", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "DOMAIN-SUFFIX,google.com" {
+		t.Fatalf("revision: %d %q", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("X-Geosite-Pinned") != "" || rec.Header().Get("Warning") != "" {
+		t.Errorf("revision reports upstream state: %v", rec.Header())
+	}
+}
+
+// snapshotETag returns the ETag of the current snapshot of source listed by
+// /snapshots.
+func snapshotETag(t *testing.T, handler http.Handler, source string) string {
+	t.Helper()
+	var snapshots []cache.Snapshot
+	if err := json.Unmarshal(get(handler, "/snapshots?source="+source, nil).Body.Bytes(), &snapshots); err != nil || len(snapshots) == 0 {
+		t.Fatalf("snapshots: %v %v", snapshots, err)
+	}
+	return snapshots[0].ETag
+}
+
+func TestRevisionRoutes(t *testing.T) {
+	srv, handler := newTestServer(t, server.Config{}, map[string]string{"google": "google.com\n"})
+	srv.AddSource("extra", newLocalSource(t, map[string]string{"google": "google.cn\nfull:www.google.cn\n"}))
+	etag := snapshotETag(t, handler, "")
+	extraETag := snapshotETag(t, handler, "extra")
+
+	tests := []struct {
+		target string
+		code   int
+		body   string
+	}{
+		{"/geosite/surge/google", http.StatusOK, "DOMAIN-SUFFIX,google.com"},
+		{"/geosite/surge/@extra/google", http.StatusOK, "DOMAIN-SUFFIX,google.cn\nDOMAIN,www.google.cn"},
+		{"/geosite/surge/@missing/google", http.StatusNotFound, ""},
+		{"/v/" + etag + "/geosite/surge/google", http.StatusOK, "DOMAIN-SUFFIX,google.com"},
+		{"/geosite/surge/google?rev=" + etag, http.StatusOK, "DOMAIN-SUFFIX,google.com"},
+		{"/v/" + extraETag + "/geosite/surge/@extra/google", http.StatusOK, "DOMAIN-SUFFIX,google.cn\nDOMAIN,www.google.cn"},
+		{"/v/" + etag + "/dat/geosite.dat?lists=google", http.StatusOK, ""},
+		// A revision belongs to the source it was listed for.
+		{"/v/" + etag + "/geosite/surge/@extra/google", http.StatusNotFound, ""},
+		{"/v/unknown/geosite/surge/google", http.StatusNotFound, ""},
+		{"/geosite/surge/google?rev=unknown", http.StatusNotFound, ""},
+		// Only the geosite, geosite.dat and PAC endpoints have revisions.
+		{"/v/" + etag + "/snapshots", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := get(handler, tt.target, nil)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.target, rec.Code, tt.code)
+			continue
+		}
+		if tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("%s = %q, want %q", tt.target, rec.Body, tt.body)
+		}
+	}
+}
@@ -2,29 +2,32 @@
 package server
 
 import (
-	"archive/zip"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/xxxbrian/surge-geosite/internal/cache"
 	"github.com/xxxbrian/surge-geosite/internal/converter"
 	"github.com/xxxbrian/surge-geosite/internal/fetcher"
 	"github.com/xxxbrian/surge-geosite/internal/geoip"
+	"github.com/xxxbrian/surge-geosite/internal/httpclient"
 	"github.com/xxxbrian/surge-geosite/internal/komari"
 )
 
 // Server represents the HTTP server
 type Server struct {
-	fetcher      *fetcher.Fetcher
+	upstream     fetcher.Upstream
 	geoIPFetcher *fetcher.GeoIPFetcher
 	resultCache  *cache.ResultCache
 	httpClient   *http.Client
@@ -35,9 +38,20 @@ type Server struct {
 	baseURL      string
 	repoURL      string
 	miscBaseURL  string
+	adminToken   string
 	indexMu      sync.RWMutex
 	indexETag    string
 	indexBody    []byte
+	// sources holds the upstreams served under "@name/"; the default
+	// upstream is stored under "".
+	sources map[string]*upstream
+}
+
+// upstream is a source of geosite lists with the include cache of its
+// snapshots.
+type upstream struct {
+	upstream     fetcher.Upstream
+	includeCache *converter.IncludeCache
 }
 
 // Config contains server configuration.
@@ -49,13 +63,27 @@ type Config struct {
 	KomariAPIKey   string
 	KomariBaseURL  string
 	KomariPathUUID string
+	// AdminToken enables the /admin endpoints for requests carrying it as a
+	// bearer token.
+	AdminToken string
+	// MiscClient and KomariClient make the requests for misc lists and to
+	// the Komari API; nil uses default clients.
+	MiscClient   *http.Client
+	KomariClient *http.Client
 }
 
 // NewServer creates a new Server
-func NewServer(f *fetcher.Fetcher, gf *fetcher.GeoIPFetcher, rc *cache.ResultCache, cfg Config) *Server {
+func NewServer(up fetcher.Upstream, gf *fetcher.GeoIPFetcher, rc *cache.ResultCache, cfg Config) *Server {
 	var kc *komari.Client
 	if cfg.KomariAPIKey != "" {
 		kc = komari.NewClient(cfg.KomariAPIKey, cfg.KomariBaseURL)
+		if cfg.KomariClient != nil {
+			kc.SetHTTPClient(cfg.KomariClient)
+		}
+	}
+	miscClient := cfg.MiscClient
+	if miscClient == nil {
+		miscClient = httpclient.Default(30 * time.Second)
 	}
 
 	// 确定 Komari 路径前缀
@@ -67,48 +95,88 @@ func NewServer(f *fetcher.Fetcher, gf *fetcher.GeoIPFetcher, rc *cache.ResultCac
 	}
 
 	return &Server{
-		fetcher:      f,
+		upstream:     up,
 		geoIPFetcher: gf,
 		resultCache:  rc,
 		komariClient: kc,
 		geoIP:        geoip.NewGeoIP(),
 		komariPrefix: prefix,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+		httpClient:   miscClient,
+		indexPath:    strings.TrimSpace(cfg.IndexPath),
+		baseURL:      strings.TrimSuffix(strings.TrimSpace(cfg.BaseURL), "/"),
+		repoURL:      cfg.RepoURL,
+		miscBaseURL:  cfg.MiscBaseURL,
+		adminToken:   cfg.AdminToken,
+		sources: map[string]*upstream{
+			"": {upstream: up, includeCache: converter.NewIncludeCache()},
 		},
-		indexPath:   strings.TrimSpace(cfg.IndexPath),
-		baseURL:     strings.TrimSuffix(strings.TrimSpace(cfg.BaseURL), "/"),
-		repoURL:     cfg.RepoURL,
-		miscBaseURL: cfg.MiscBaseURL,
 	}
 }
 
+// AddSource serves the lists of up under "/geosite/@name/", next to the
+// default upstream. It must be called before the server starts.
+func (s *Server) AddSource(name string, up fetcher.Upstream) {
+	s.sources[strings.ToLower(name)] = &upstream{upstream: up, includeCache: converter.NewIncludeCache()}
+}
+
+// lookupSource returns the upstream named by a "@name/" path prefix, or the
+// default upstream for "".
+func (s *Server) lookupSource(name string) (*upstream, bool) {
+	src, ok := s.sources[name]
+	return src, ok
+}
+
+// cutSource splits a leading "@name/" source namespace off a request path.
+func cutSource(path string) (source, rest string) {
+	if !strings.HasPrefix(path, "@") {
+		return "", path
+	}
+	source, rest, _ = strings.Cut(path[1:], "/")
+	return source, rest
+}
+
 // SetupRoutes configures the HTTP routes
 func (s *Server) SetupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/", s.handleRoot)
 	mux.HandleFunc("/geosite", s.handleGeositeIndex)
 	mux.HandleFunc("/geosite/", s.handleGeosite)
-	mux.HandleFunc("/geosite/surge", s.handleGeositeIndex)
-	mux.HandleFunc("/geosite/surge/", s.handleSurge)
-	mux.HandleFunc("/geosite/mihomo", s.handleGeositeIndex)
-	mux.HandleFunc("/geosite/mihomo/", s.handleMihomo)
-	mux.HandleFunc("/geosite/egern", s.handleGeositeIndex)
-	mux.HandleFunc("/geosite/egern/", s.handleEgern)
 	mux.HandleFunc("/misc/", s.handleMisc)
+	mux.HandleFunc("/dat/geosite.dat", s.handleGeositeDat)
+	mux.HandleFunc("/pac", s.handlePAC)
+	mux.HandleFunc("/snapshots", s.handleSnapshots)
+	mux.HandleFunc("/v/", s.handleRevision(mux))
 
 	// GeoIP routes
 	mux.HandleFunc("/geoip/", s.handleGeoIP)
-	mux.HandleFunc("/geoip/surge/", s.handleGeoIPSurge)
-	mux.HandleFunc("/geoip/mihomo/", s.handleGeoIPMihomo)
-	mux.HandleFunc("/geoip/egern/", s.handleGeoIPEgern)
 
 	// Komari IP CIDR 路由
 	// 使用动态前缀注册路由
 	mux.HandleFunc(s.komariPrefix+"/ipcidr", s.handleKomariIPCIDR)
 	mux.HandleFunc(s.komariPrefix+"/ipcidr/", s.handleKomariIPCIDR)
-	mux.HandleFunc(s.komariPrefix+"/surge/", s.handleKomariSurge)
-	mux.HandleFunc(s.komariPrefix+"/mihomo/", s.handleKomariMihomo)
-	mux.HandleFunc(s.komariPrefix+"/egern/", s.handleKomariEgern)
+
+	// Client prefixes come from the renderer registry: every format that can
+	// express domain rules gets a /geosite/<name>/ route, and every format
+	// that can express IP rules gets /geoip/<name>/ and a Komari route.
+	for _, f := range converter.Renderers() {
+		name := f.Name()
+		if converter.SupportsDomains(f) {
+			index := s.handleGeositeIndex
+			if name == "surge-domainset" {
+				index = s.handleDomainSetIndex
+			}
+			mux.HandleFunc("/geosite/"+name, index)
+			mux.HandleFunc("/geosite/"+name+"/", s.handleClient(name))
+		}
+		if converter.SupportsIPs(f) {
+			mux.HandleFunc("/geoip/"+name+"/", s.handleGeoIPClient(name))
+			mux.HandleFunc(s.komariPrefix+"/"+name+"/", s.handleKomariClient(name))
+		}
+	}
+
+	if s.adminToken != "" {
+		mux.HandleFunc("/admin/pin", s.requireAdmin(s.handleAdminPin))
+		mux.HandleFunc("/admin/rollback", s.requireAdmin(s.handleAdminRollback))
+	}
 }
 
 // handleRoot redirects to GitHub repository
@@ -122,6 +190,11 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 
 // handleGeositeIndex returns the JSON index of available geosites
 func (s *Server) handleGeositeIndex(w http.ResponseWriter, r *http.Request) {
+	if revisionPrefix(r) != "" {
+		s.writeSourceIndex(w, r, s.sources[""], s.geositeBaseURL(r))
+		return
+	}
+	setUpstreamHeaders(w, s.upstream)
 	// Priority 1: Read from indexPath file if exists
 	if s.indexPath != "" {
 		if body, err := os.ReadFile(s.indexPath); err == nil {
@@ -141,107 +214,340 @@ func (s *Server) handleGeositeIndex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Priority 3: Generate dynamically from request
-	if err := s.writeIndexFromZip(w, r); err != nil {
+	if err := s.writeIndex(w, r); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to generate index: %v", err), http.StatusInternalServerError)
 	}
 }
 
 // handleGeosite handles /geosite/:name_with_filter requests
 func (s *Server) handleGeosite(w http.ResponseWriter, r *http.Request) {
-	s.handleRuleset(w, r, "/geosite/", "geosite")
+	s.handleRuleset(w, r, "/geosite/", "surge")
 }
 
-// handleSurge handles /geosite/surge/:name_with_filter requests
-func (s *Server) handleSurge(w http.ResponseWriter, r *http.Request) {
-	s.handleRuleset(w, r, "/geosite/surge/", "geosite")
-}
-
-// handleMihomo handles /mihomo/:name_with_filter requests
-func (s *Server) handleMihomo(w http.ResponseWriter, r *http.Request) {
-	s.handleRuleset(w, r, "/geosite/mihomo/", "mihomo")
-}
-
-// handleEgern handles /egern/:name_with_filter requests
-func (s *Server) handleEgern(w http.ResponseWriter, r *http.Request) {
-	s.handleRuleset(w, r, "/geosite/egern/", "egern")
+// handleClient returns a handler for /geosite/<client>/:name_with_filter requests
+func (s *Server) handleClient(client string) http.HandlerFunc {
+	prefix := "/geosite/" + client + "/"
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.handleRuleset(w, r, prefix, client)
+	}
 }
 
 func (s *Server) handleRuleset(w http.ResponseWriter, r *http.Request, prefix string, format string) {
 	nameWithFilter := strings.TrimPrefix(r.URL.Path, prefix)
 	nameWithFilter = strings.ToLower(strings.TrimSpace(nameWithFilter))
+	sourceName, nameWithFilter := cutSource(nameWithFilter)
+	src, ok := s.lookupSource(sourceName)
+	if !ok {
+		http.Error(w, "Unknown source: "+sourceName, http.StatusNotFound)
+		return
+	}
+	sourcePrefix := ""
+	if sourceName != "" {
+		sourcePrefix = "@" + sourceName + "/"
+		if nameWithFilter == "" {
+			s.writeSourceIndex(w, r, src, s.geositeBaseURL(r)+"/"+strings.TrimSuffix(sourcePrefix, "/"))
+			return
+		}
+	}
+	format, nameWithFilter = formatVariant(format, nameWithFilter, r.URL.Query().Get("behavior"))
+	outFormat := negotiateFormat(w, r, domainRules, format)
+	if outFormat == nil {
+		return
+	}
+	format = outFormat.Name()
 
 	if nameWithFilter == "" {
 		http.Error(w, "Invalid name parameter", http.StatusBadRequest)
 		return
 	}
 
-	var name, filter string
-	if strings.Contains(nameWithFilter, "@") {
-		parts := strings.SplitN(nameWithFilter, "@", 2)
-		name = parts[0]
-		filter = parts[1]
-	} else {
-		name = nameWithFilter
-	}
-
+	name, filterExpr, _ := strings.Cut(nameWithFilter, "@")
 	if name == "" {
 		http.Error(w, "Invalid name parameter", http.StatusBadRequest)
 		return
 	}
 
-	zipReader, etag, err := s.fetcher.GetZipReader()
+	// "a+b+c" combines several lists; the sorted name set identifies the result.
+	var names []string
+	if strings.Contains(name, "+") {
+		var err error
+		names, err = converter.ParseListNames(name, "+")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid name parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		name = strings.Join(names, "+")
+	}
+
+	filter, err := converter.ParseFilter(filterExpr)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to fetch upstream: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Invalid filter: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	cacheKey := format + ":" + nameWithFilter
-	if result, ok := s.resultCache.Get(cacheKey, etag); ok {
-		log.Printf("Cache hit for %s (ETag %s)", cacheKey, truncateETag(etag))
-		s.writeRulesetResponse(w, format, result)
+	setOps := make(map[string][]string)
+	for _, op := range []string{"exclude", "intersect"} {
+		raw := r.URL.Query().Get(op)
+		if raw == "" {
+			continue
+		}
+		operands, err := converter.ParseListNames(raw, ",")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid %s parameter: %v", op, err), http.StatusBadRequest)
+			return
+		}
+		setOps[op] = operands
+	}
+
+	lists, etag, rev, err := revisionLists(src, r)
+	if err != nil {
+		writeListsError(w, err)
 		return
 	}
+	setRevisionHeaders(w, src, rev)
 
-	log.Printf("Cache miss for %s, generating...", cacheKey)
+	optimize := queryFlag(r, "optimize")
 
-	upstreamContent, err := s.fetcher.GetFileContent(zipReader, name)
+	cacheKey := format + ":" + sourcePrefix + name
+	if rev != "" {
+		cacheKey += "?rev=" + rev
+	}
+	if !filter.IsEmpty() {
+		cacheKey += "@" + filter.String()
+	}
+	for _, op := range []string{"exclude", "intersect"} {
+		if operands, ok := setOps[op]; ok {
+			cacheKey += "?" + op + "=" + strings.Join(operands, ",")
+		}
+	}
+	if optimize {
+		cacheKey += "?optimize"
+	}
+	residual := format == "surge" && queryFlag(r, "residual")
+	if residual {
+		cacheKey += "?residual"
+	}
+	opts, err := renderOptionsFrom(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get upstream content: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if format == "surge-domainset" {
+		ruleSetName := name
+		if !filter.IsEmpty() {
+			ruleSetName += "@" + filter.String()
+		}
+		residualPath := revisionPath(rev) + "/geosite/surge/" + sourcePrefix + ruleSetName + "?residual=1"
+		// The cached output only names the residual rule-set below the
+		// configured base URL, never below the Host of a request.
+		opts.ResidualURL = s.baseURL + residualPath
+		w.Header().Set("X-Geosite-Residual-URL", s.siteURL(r)+residualPath)
+	}
+	if format == "quantumultx" {
+		cacheKey += "?policy=" + opts.Policy
+	}
+	if dnsFormats[format] {
+		if err := validateDNSOptions(opts.DNS); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cacheKey += "?upstream=" + opts.DNS.Upstream + "&sinkhole=" + opts.DNS.Sinkhole
+	}
+	if queryFlag(r, "meta") {
+		cacheKey += "?meta"
+	}
+	if result, headers, ok := s.resultCache.GetWithHeaders(cacheKey, etag); ok {
+		log.Printf("Cache hit for %s (ETag %s)", cacheKey, truncateETag(etag))
+		writeRulesetBody(w, outFormat, result, headers)
 		return
 	}
 
-	conv := converter.NewConverter(zipReader, s.fetcher.GetFileContent)
-	var output string
-	switch format {
-	case "mihomo":
-		output, err = conv.ConvertMihomo(upstreamContent, filter)
-	case "egern":
-		output, err = conv.ConvertEgern(upstreamContent, filter)
-	default:
-		output, err = conv.Convert(upstreamContent, filter)
+	log.Printf("Cache miss for %s, generating...", cacheKey)
+
+	conv := converter.NewConverter(lists)
+	setIncludeCache(conv, src, etag, rev)
+	var items []converter.Item
+	if names != nil {
+		items, err = conv.ParseCombined(names, filter)
+	} else {
+		var upstreamContent string
+		upstreamContent, err = lists.ReadList(name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get upstream content: %v", err), http.StatusInternalServerError)
+			return
+		}
+		items, err = conv.Parse(upstreamContent, filter)
 	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to convert: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	s.resultCache.Set(cacheKey, output, etag)
+	headers := make(map[string]string)
+	if len(setOps) > 0 {
+		removed := 0
+		for _, op := range []string{"exclude", "intersect"} {
+			operands, ok := setOps[op]
+			if !ok {
+				continue
+			}
+			// Operand lists are not filtered: they describe the domains to remove or keep.
+			other, err := conv.ParseCombined(operands, converter.Filter{})
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to convert %s lists: %v", op, err), http.StatusInternalServerError)
+				return
+			}
+			var n int
+			if op == "exclude" {
+				items, n = converter.Exclude(items, other)
+			} else {
+				items, n = converter.Intersect(items, other)
+			}
+			removed += n
+		}
+		headers["X-Geosite-Setop-Removed"] = strconv.Itoa(removed)
+	}
+	if optimize {
+		var removed int
+		items, removed = converter.Optimize(items)
+		headers["X-Geosite-Optimize-Removed"] = strconv.Itoa(removed)
+	}
+	if residual {
+		items = converter.DomainSetResidual(items)
+	}
+
+	meta := converter.NewMetadata(items, outFormat)
+	meta.Name = sourcePrefix + name
+	meta.Filter = filter.String()
+	meta.ETag = etag
+	meta.Commit = lists.Commit()
+
+	output, err := outFormat.Render(items, opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to render: %v", err), http.StatusInternalServerError)
+		return
+	}
+	body, ok := writeRulesetResponse(w, r, outFormat, output, meta, headers)
+	if !ok {
+		return
+	}
+	s.resultCache.SetWithHeaders(cacheKey, body, etag, headers)
 
 	log.Printf("Generated and cached result for %s (ETag %s)", cacheKey, truncateETag(etag))
+}
 
-	s.writeRulesetResponse(w, format, output)
+// renderOptionsFrom reads the rendering parameters of a request, rejecting a
+// policy that would inject extra rules into Quantumult X output.
+func renderOptionsFrom(r *http.Request) (converter.RenderOptions, error) {
+	query := r.URL.Query()
+	opts := converter.RenderOptions{
+		Policy: strings.TrimSpace(query.Get("policy")),
+		DNS: converter.DNSOptions{
+			Upstream: strings.TrimSpace(query.Get("upstream")),
+			Sinkhole: strings.TrimSpace(query.Get("sinkhole")),
+		},
+	}
+	if opts.Policy == "" {
+		opts.Policy = "proxy"
+	}
+	if strings.ContainsAny(opts.Policy, "\r\n,") {
+		return opts, fmt.Errorf("invalid policy: %q", opts.Policy)
+	}
+	return opts, nil
 }
 
-func (s *Server) writeRulesetResponse(w http.ResponseWriter, format string, body string) {
-	contentType := "text/plain; charset=utf-8"
-	if format == "egern" {
-		contentType = "text/yaml; charset=utf-8"
+// validateDNSOptions rejects values that would inject extra lines or break
+// quoting in the generated resolver configuration. "#" stays allowed for the
+// dnsmasq "ip#port" and unbound "ip@port#name" upstream syntax.
+func validateDNSOptions(opts converter.DNSOptions) error {
+	if strings.ContainsFunc(opts.Upstream, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) ||
+		strings.ContainsAny(opts.Upstream, "\"'") {
+		return fmt.Errorf("invalid upstream: %q", opts.Upstream)
+	}
+	if opts.Sinkhole != "" {
+		if _, err := netip.ParseAddr(opts.Sinkhole); err != nil {
+			return fmt.Errorf("invalid sinkhole: %q", opts.Sinkhole)
+		}
+	}
+	return nil
+}
+
+// dnsFormats are the DNS-layer formats taking upstream and sinkhole options.
+var dnsFormats = map[string]bool{
+	"adguard": true, "dnsmasq": true, "smartdns": true, "unbound": true, "hosts": true,
+}
+
+// formatVariant resolves the concrete output format from the endpoint format,
+// the mihomo behavior query parameter and a file extension on the requested
+// name, e.g. "google@cn.srs" for sing-box or "google.mrs?behavior=domain"
+// for mihomo.
+func formatVariant(format string, name string, behavior string) (string, string) {
+	ext := ""
+	for _, e := range []string{".srs", ".mrs", ".yaml", ".json"} {
+		if trimmed, ok := strings.CutSuffix(name, e); ok {
+			name, ext = trimmed, e
+			break
+		}
+	}
+	behavior = strings.ToLower(strings.TrimSpace(behavior))
+	if format == "mihomo" && behavior != "" && behavior != "classical" {
+		format += "-" + behavior
+	}
+	switch ext {
+	case ".srs", ".mrs", ".yaml":
+		format += "-" + strings.TrimPrefix(ext, ".")
+	}
+	return format, name
+}
+
+// writeRulesetResponse writes output freshly rendered by f, describing it
+// with meta: as X-Geosite-* headers, added to headers, and in the body when
+// the request asks for it with ?meta=1. It returns the body written, for
+// callers caching it with headers, and false after writing an error instead.
+func writeRulesetResponse(w http.ResponseWriter, r *http.Request, f converter.Renderer, output string, meta converter.Metadata, headers map[string]string) (string, bool) {
+	meta.Generated = time.Now()
+	for key, value := range meta.Headers() {
+		headers[key] = value
+	}
+	if queryFlag(r, "meta") {
+		var err error
+		output, err = converter.Annotate(f, output, meta)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to render metadata: %v", err), http.StatusInternalServerError)
+			return "", false
+		}
+	}
+	writeRulesetBody(w, f, output, headers)
+	return output, true
+}
+
+// writeRulesetBody writes a ruleset rendered by f with its headers. It is
+// cacheable for 30 minutes unless the caller set Cache-Control.
+func writeRulesetBody(w http.ResponseWriter, f converter.Renderer, body string, headers map[string]string) {
+	for key, value := range headers {
+		w.Header().Set(key, value)
+	}
+	w.Header().Set("Content-Type", f.ContentType())
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "public, max-age=1800")
 	}
-	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Cache-Control", "public, max-age=1800")
 	w.Write([]byte(body))
 }
 
+// queryFlag reports whether a boolean query parameter is set, accepting
+// "?name", "?name=1" and "?name=true".
+func queryFlag(r *http.Request, name string) bool {
+	values, ok := r.URL.Query()[name]
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(values[0]) {
+	case "", "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
 // handleMisc handles /misc/:category/:name requests
 func (s *Server) handleMisc(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/misc/")
@@ -255,6 +561,22 @@ func (s *Server) handleMisc(w http.ResponseWriter, r *http.Request) {
 	category := strings.ToLower(parts[0])
 	name := strings.ToLower(parts[1])
 
+	outFormat := negotiateFormat(w, r, mixedRules, "surge")
+	if outFormat == nil {
+		return
+	}
+	opts, err := renderOptionsFrom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if dnsFormats[outFormat.Name()] {
+		if err := validateDNSOptions(opts.DNS); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	url := fmt.Sprintf("%s/%s/%s.list", s.miscBaseURL, category, name)
 
 	resp, err := s.httpClient.Get(url)
@@ -275,9 +597,208 @@ func (s *Server) handleMisc(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	items, skipped := converter.ParseRuleList(string(body))
+	meta := converter.NewMetadata(items, outFormat)
+	meta.Name = category + "/" + name
+	meta.Skipped += len(skipped)
+
+	// Surge lists are passed through unchanged; other formats are rendered
+	// from the parsed rules.
+	output := string(body)
+	if outFormat.Name() != "surge" {
+		output, err = outFormat.Render(items, opts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to render: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeRulesetResponse(w, r, outFormat, output, meta, make(map[string]string))
+}
+
+// handleGeositeDat handles /dat/geosite.dat?lists=a,b,c requests, building a
+// v2ray geosite.dat containing only the selected lists.
+func (s *Server) handleGeositeDat(w http.ResponseWriter, r *http.Request) {
+	names, err := converter.ParseListNames(r.URL.Query().Get("lists"), ",")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid lists parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	sourceName := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("source")))
+	src, ok := s.lookupSource(sourceName)
+	if !ok {
+		http.Error(w, "Unknown source: "+sourceName, http.StatusNotFound)
+		return
+	}
+
+	lists, etag, rev, err := revisionLists(src, r)
+	if err != nil {
+		writeListsError(w, err)
+		return
+	}
+	setRevisionHeaders(w, src, rev)
+
+	cacheKey := "geosite-dat:" + strings.Join(names, ",")
+	if sourceName != "" {
+		cacheKey += "?source=" + sourceName
+	}
+	if rev != "" {
+		cacheKey += "?rev=" + rev
+	}
+	if result, ok := s.resultCache.Get(cacheKey, etag); ok {
+		log.Printf("Cache hit for %s (ETag %s)", cacheKey, truncateETag(etag))
+		writeGeositeDat(w, result)
+		return
+	}
+
+	log.Printf("Cache miss for %s, generating...", cacheKey)
+
+	conv := converter.NewConverter(lists)
+	setIncludeCache(conv, src, etag, rev)
+	data, err := conv.BuildGeoSiteDat(names)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to build geosite.dat: %v", err), http.StatusInternalServerError)
+		return
+	}
+	s.resultCache.Set(cacheKey, string(data), etag)
+
+	log.Printf("Generated and cached result for %s (ETag %s)", cacheKey, truncateETag(etag))
+
+	writeGeositeDat(w, string(data))
+}
+
+func writeGeositeDat(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="geosite.dat"`)
+	w.Header().Set("Cache-Control", "public, max-age=1800")
+	w.Write([]byte(body))
+}
+
+// handlePAC handles /pac requests. Geosite lists in "proxy" and "direct" and
+// GeoIP codes in "proxy-ip" and "direct-ip" are routed to the proxy given by
+// "server" or connected directly; "default" selects the target of other hosts.
+func (s *Server) handlePAC(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	server := strings.TrimSpace(query.Get("server"))
+	if server == "" || strings.ContainsAny(server, "\r\n") {
+		http.Error(w, "Invalid server parameter, expected e.g. \"PROXY 127.0.0.1:7890\"", http.StatusBadRequest)
+		return
+	}
+	defaultTarget := strings.ToLower(strings.TrimSpace(query.Get("default")))
+	if defaultTarget != "" && defaultTarget != "direct" && defaultTarget != "proxy" {
+		http.Error(w, "Invalid default parameter, expected direct or proxy", http.StatusBadRequest)
+		return
+	}
+
+	var lists [2][]string
+	var codes [2][]string
+	for i, target := range []string{"direct", "proxy"} {
+		if raw := query.Get(target); raw != "" {
+			names, err := converter.ParseListNames(raw, ",")
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s parameter: %v", target, err), http.StatusBadRequest)
+				return
+			}
+			lists[i] = names
+		}
+		if raw := query.Get(target + "-ip"); raw != "" {
+			codes[i] = parseGeoIPCodes(raw)
+		}
+	}
+	if len(lists[0])+len(lists[1])+len(codes[0])+len(codes[1]) == 0 {
+		http.Error(w, "No geosite lists or GeoIP codes given", http.StatusBadRequest)
+		return
+	}
+
+	sourceName := strings.ToLower(strings.TrimSpace(query.Get("source")))
+	src, ok := s.lookupSource(sourceName)
+	if !ok {
+		http.Error(w, "Unknown source: "+sourceName, http.StatusNotFound)
+		return
+	}
+
+	snapshot, etag, rev, err := revisionLists(src, r)
+	if err != nil {
+		writeListsError(w, err)
+		return
+	}
+	setRevisionHeaders(w, src, rev)
+
+	cacheKey := fmt.Sprintf("pac:source=%s&rev=%s&direct=%s&proxy=%s&direct-ip=%s&proxy-ip=%s&server=%s&default=%s",
+		sourceName, rev, strings.Join(lists[0], ","), strings.Join(lists[1], ","),
+		strings.Join(codes[0], ","), strings.Join(codes[1], ","), server, defaultTarget)
+	// The script also depends on the loaded GeoIP data.
+	cacheETag := etag + "/geoip-" + strconv.FormatUint(s.geoIP.Generation(), 10)
+	if result, ok := s.resultCache.Get(cacheKey, cacheETag); ok {
+		log.Printf("Cache hit for %s (ETag %s)", cacheKey, truncateETag(etag))
+		writePAC(w, result)
+		return
+	}
+
+	log.Printf("Cache miss for %s, generating...", cacheKey)
+
+	conv := converter.NewConverter(snapshot)
+	setIncludeCache(conv, src, etag, rev)
+	var rules [2]converter.PACRules
+	for i := range rules {
+		for _, name := range lists[i] {
+			content, err := snapshot.ReadList(name)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to get upstream content: %v", err), http.StatusInternalServerError)
+				return
+			}
+			items, err := conv.Parse(content, converter.Filter{})
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to convert: %v", err), http.StatusInternalServerError)
+				return
+			}
+			rules[i].Items = append(rules[i].Items, items...)
+		}
+		for _, code := range codes[i] {
+			cidrs, ok := s.geoIP.GetCIDRs(code)
+			if !ok {
+				http.Error(w, "GeoIP code not found: "+code, http.StatusNotFound)
+				return
+			}
+			rules[i].CIDRs = append(rules[i].CIDRs, cidrs...)
+		}
+	}
+
+	output, err := converter.RenderPAC(rules[0], rules[1], converter.PACOptions{
+		Proxy:        server,
+		DefaultProxy: defaultTarget == "proxy",
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to render: %v", err), http.StatusInternalServerError)
+		return
+	}
+	s.resultCache.Set(cacheKey, output, cacheETag)
+
+	log.Printf("Generated and cached result for %s (ETag %s)", cacheKey, truncateETag(etag))
+
+	writePAC(w, output)
+}
+
+// parseGeoIPCodes parses a comma-separated list of GeoIP codes, returning
+// them uppercased, deduplicated and sorted.
+func parseGeoIPCodes(raw string) []string {
+	seen := make(map[string]bool)
+	var codes []string
+	for _, code := range strings.Split(raw, ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code != "" && !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+func writePAC(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
 	w.Header().Set("Cache-Control", "public, max-age=1800")
-	w.Write(body)
+	w.Write([]byte(body))
 }
 
 // handleKomariIPCIDR 处理 IP CIDR 请求
@@ -287,19 +808,12 @@ func (s *Server) handleKomariIPCIDR(w http.ResponseWriter, r *http.Request) {
 	s.handleKomariRuleset(w, r, s.komariPrefix+"/ipcidr", "surge")
 }
 
-// handleKomariSurge 处理 Surge 请求
-func (s *Server) handleKomariSurge(w http.ResponseWriter, r *http.Request) {
-	s.handleKomariRuleset(w, r, s.komariPrefix+"/surge/", "surge")
-}
-
-// handleKomariMihomo 处理 Mihomo 请求
-func (s *Server) handleKomariMihomo(w http.ResponseWriter, r *http.Request) {
-	s.handleKomariRuleset(w, r, s.komariPrefix+"/mihomo/", "mihomo")
-}
-
-// handleKomariEgern 处理 Egern 请求
-func (s *Server) handleKomariEgern(w http.ResponseWriter, r *http.Request) {
-	s.handleKomariRuleset(w, r, s.komariPrefix+"/egern/", "egern")
+// handleKomariClient 返回处理 <komariPrefix>/<client>/ 请求的 handler
+func (s *Server) handleKomariClient(client string) http.HandlerFunc {
+	prefix := s.komariPrefix + "/" + client + "/"
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.handleKomariRuleset(w, r, prefix, client)
+	}
 }
 
 // handleKomariRuleset 通用 Komari ruleset 处理函数
@@ -312,6 +826,11 @@ func (s *Server) handleKomariRuleset(w http.ResponseWriter, r *http.Request, pre
 	// 解析路径和过滤器
 	nameWithFilter := strings.TrimPrefix(r.URL.Path, prefix)
 	nameWithFilter = strings.ToLower(strings.TrimSpace(nameWithFilter))
+	format, nameWithFilter = formatVariant(format, nameWithFilter, r.URL.Query().Get("behavior"))
+	outFormat := negotiateFormat(w, r, ipRules, format)
+	if outFormat == nil {
+		return
+	}
 
 	// 目前只支持 ipcidr
 	var name, filterStr string
@@ -367,24 +886,23 @@ func (s *Server) handleKomariRuleset(w http.ResponseWriter, r *http.Request, pre
 	cidrs := komari.GenerateIPCIDR(clients, filter, getPing)
 
 	// 根据格式渲染输出
-	var output string
-	var contentType string
-
-	switch format {
-	case "egern":
-		output = komari.RenderEgern(cidrs)
-		contentType = "text/yaml; charset=utf-8"
-	case "mihomo":
-		output = komari.RenderMihomo(cidrs)
-		contentType = "text/plain; charset=utf-8"
-	default: // surge
-		output = komari.RenderSurge(cidrs)
-		contentType = "text/plain; charset=utf-8"
-	}
-
-	w.Header().Set("Content-Type", contentType)
+	opts, err := renderOptionsFrom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	items := komariItems(cidrs)
+	output, err := outFormat.Render(items, opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to render: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	meta := converter.NewMetadata(items, outFormat)
+	meta.Name = "ipcidr"
+	meta.Filter = string(filter)
 	w.Header().Set("Cache-Control", "public, max-age=300")
-	w.Write([]byte(output))
+	writeRulesetResponse(w, r, outFormat, output, meta, make(map[string]string))
 }
 
 // LoggingMiddleware logs all HTTP requests
@@ -414,16 +932,18 @@ func (s *Server) RefreshIndex() error {
 		return nil
 	}
 
-	zipReader, etag, err := s.fetcher.GetZipReader()
+	lists, etag, err := s.upstream.Lists()
 	if err != nil {
 		return err
 	}
+	pinned := s.upstream.Pinned()
+	version := indexVersion(etag, pinned)
 
 	// Check if we already have this version cached
 	s.indexMu.RLock()
 	currentETag := s.indexETag
 	s.indexMu.RUnlock()
-	if currentETag == etag && s.indexPath != "" {
+	if currentETag == version && s.indexPath != "" {
 		// Check if file exists
 		if _, err := os.Stat(s.indexPath); err == nil {
 			return nil
@@ -431,13 +951,13 @@ func (s *Server) RefreshIndex() error {
 	}
 
 	// Build index with correct URL format: baseURL + /geosite/ + name
-	body, err := s.buildIndexFromZip(zipReader, s.baseURL+"/geosite")
+	body, err := buildIndex(lists, s.baseURL+"/geosite", pinned)
 	if err != nil {
 		return err
 	}
 
 	// Update in-memory cache
-	s.setCachedIndex(etag, body)
+	s.setCachedIndex(version, body)
 
 	// Save to file if indexPath is configured
 	if s.indexPath != "" {
@@ -451,17 +971,18 @@ func (s *Server) RefreshIndex() error {
 	return nil
 }
 
-func (s *Server) writeIndexFromZip(w http.ResponseWriter, r *http.Request) error {
-	zipReader, etag, err := s.fetcher.GetZipReader()
+func (s *Server) writeIndex(w http.ResponseWriter, r *http.Request) error {
+	lists, etag, err := s.upstream.Lists()
 	if err != nil {
 		return err
 	}
 
 	baseURL := buildBaseURL(r)
+	pinned := s.upstream.Pinned()
 
 	// Check memory cache
 	s.indexMu.RLock()
-	if s.indexBody != nil && s.indexETag == etag {
+	if s.indexBody != nil && s.indexETag == indexVersion(etag, pinned) {
 		body := s.indexBody
 		s.indexMu.RUnlock()
 		w.Header().Set("Content-Type", "application/json")
@@ -471,7 +992,7 @@ func (s *Server) writeIndexFromZip(w http.ResponseWriter, r *http.Request) error
 	}
 	s.indexMu.RUnlock()
 
-	body, err := s.buildIndexFromZip(zipReader, baseURL)
+	body, err := buildIndex(lists, baseURL, pinned)
 	if err != nil {
 		return err
 	}
@@ -482,6 +1003,50 @@ func (s *Server) writeIndexFromZip(w http.ResponseWriter, r *http.Request) error
 	return nil
 }
 
+// handleDomainSetIndex returns the JSON index with Surge DOMAIN-SET URLs
+func (s *Server) handleDomainSetIndex(w http.ResponseWriter, r *http.Request) {
+	s.writeSourceIndex(w, r, s.sources[""], s.geositeBaseURL(r)+"/surge-domainset")
+}
+
+// writeSourceIndex writes the JSON index of the lists of src, linking them
+// below baseURL.
+func (s *Server) writeSourceIndex(w http.ResponseWriter, r *http.Request, src *upstream, baseURL string) {
+	lists, _, rev, err := revisionLists(src, r)
+	if err != nil {
+		writeListsError(w, err)
+		return
+	}
+	setRevisionHeaders(w, src, rev)
+
+	pinned := ""
+	if rev == "" {
+		pinned = src.upstream.Pinned()
+	}
+	body, err := buildIndex(lists, baseURL, pinned)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to generate index: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=1800")
+	_, _ = w.Write(body)
+}
+
+// geositeBaseURL returns the configured base URL of geosite endpoints, or
+// one derived from the request, below the revision the request selects.
+func (s *Server) geositeBaseURL(r *http.Request) string {
+	return s.siteURL(r) + revisionPrefix(r) + "/geosite"
+}
+
+// siteURL returns the configured base URL, or one derived from the request.
+func (s *Server) siteURL(r *http.Request) string {
+	if s.baseURL != "" {
+		return s.baseURL
+	}
+	return strings.TrimSuffix(buildBaseURL(r), "/geosite")
+}
+
 func buildBaseURL(r *http.Request) string {
 	host := r.Header.Get("X-Forwarded-Host")
 	if host == "" {
@@ -498,37 +1063,28 @@ func buildBaseURL(r *http.Request) string {
 	return proto + "://" + host + "/geosite"
 }
 
-func (s *Server) buildIndexFromZip(zipReader *zip.Reader, geositeBaseURL string) ([]byte, error) {
-	const dataPrefix = "domain-list-community-master/data/"
+// buildIndex maps the names of lists to their URLs. A "pinned" entry holds
+// the ref the upstream is pinned to, if any.
+func buildIndex(lists fetcher.Lists, geositeBaseURL, pinned string) ([]byte, error) {
 	index := make(map[string]string)
-
-	for _, file := range zipReader.File {
-		if !strings.HasPrefix(file.Name, dataPrefix) {
-			continue
-		}
-		name := strings.TrimPrefix(file.Name, dataPrefix)
-		if name == "" {
-			continue
-		}
-		if strings.Contains(name, "/") {
-			continue
-		}
+	for _, name := range lists.Names() {
 		// geositeBaseURL is already like "http://example.com/geosite"
 		index[name] = strings.TrimRight(geositeBaseURL, "/") + "/" + name
 	}
-
-	ordered := make([]string, 0, len(index))
-	for name := range index {
-		ordered = append(ordered, name)
+	if pinned != "" {
+		index["pinned"] = pinned
 	}
-	sort.Strings(ordered)
 
-	orderedIndex := make(map[string]string, len(index))
-	for _, name := range ordered {
-		orderedIndex[name] = index[name]
-	}
+	return json.MarshalIndent(index, "", "  ")
+}
 
-	return json.MarshalIndent(orderedIndex, "", "  ")
+// indexVersion identifies the cached index of the snapshot with etag, which
+// changes when the upstream is pinned or unpinned.
+func indexVersion(etag, pinned string) string {
+	if pinned == "" {
+		return etag
+	}
+	return etag + " pinned " + pinned
 }
 
 func (s *Server) getCachedIndex() ([]byte, bool) {
@@ -582,21 +1138,21 @@ func (s *Server) handleGeoIP(w http.ResponseWriter, r *http.Request) {
 	s.serveGeoIP(w, r, "/geoip/", "list")
 }
 
-func (s *Server) handleGeoIPSurge(w http.ResponseWriter, r *http.Request) {
-	s.serveGeoIP(w, r, "/geoip/surge/", "surge")
-}
-
-func (s *Server) handleGeoIPMihomo(w http.ResponseWriter, r *http.Request) {
-	s.serveGeoIP(w, r, "/geoip/mihomo/", "mihomo")
-}
-
-func (s *Server) handleGeoIPEgern(w http.ResponseWriter, r *http.Request) {
-	s.serveGeoIP(w, r, "/geoip/egern/", "egern")
+func (s *Server) handleGeoIPClient(client string) http.HandlerFunc {
+	prefix := "/geoip/" + client + "/"
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.serveGeoIP(w, r, prefix, client)
+	}
 }
 
 func (s *Server) serveGeoIP(w http.ResponseWriter, r *http.Request, prefix string, format string) {
 	code := strings.TrimPrefix(r.URL.Path, prefix)
 	code = strings.TrimSpace(code)
+	format, code = formatVariant(format, code, r.URL.Query().Get("behavior"))
+	outFormat := negotiateFormat(w, r, ipRules, format)
+	if outFormat == nil {
+		return
+	}
 	if code == "" {
 		http.Error(w, "Invalid code parameter", http.StatusBadRequest)
 		return
@@ -611,35 +1167,29 @@ func (s *Server) serveGeoIP(w http.ResponseWriter, r *http.Request, prefix strin
 		return
 	}
 
-	var output string
-	var contentType string
-
-	switch format {
-	case "egern":
-		output = komari.RenderEgern(convertToIPCIDR(cidrs))
-		contentType = "text/yaml; charset=utf-8"
-	case "mihomo", "surge":
-		output = komari.RenderSurge(convertToIPCIDR(cidrs))
-		contentType = "text/plain; charset=utf-8"
-	default:
-		// Plain list
-		output = strings.Join(cidrs, "\n")
-		contentType = "text/plain; charset=utf-8"
+	opts, err := renderOptionsFrom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	items := converter.IPCIDRItems(cidrs)
+	output, err := outFormat.Render(items, opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to render: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	w.Header().Set("Content-Type", contentType)
+	meta := converter.NewMetadata(items, outFormat)
+	meta.Name = code
 	w.Header().Set("Cache-Control", "public, max-age=3600")
-	w.Write([]byte(output))
+	writeRulesetResponse(w, r, outFormat, output, meta, make(map[string]string))
 }
 
-func convertToIPCIDR(cidrs []string) []komari.IPCIDR {
-	var result []komari.IPCIDR
-	for _, ip := range cidrs {
-		isIPv6 := strings.Contains(ip, ":")
-		result = append(result, komari.IPCIDR{
-			IP:     ip,
-			IsIPv6: isIPv6,
-		})
+// komariItems wraps Komari IP CIDR rules into rule items.
+func komariItems(cidrs []komari.IPCIDR) []converter.Item {
+	values := make([]string, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		values = append(values, cidr.IP)
 	}
-	return result
+	return converter.IPCIDRItems(values)
 }
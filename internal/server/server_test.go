@@ -0,0 +1,162 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xxxbrian/surge-geosite/internal/cache"
+	"github.com/xxxbrian/surge-geosite/internal/converter"
+	"github.com/xxxbrian/surge-geosite/internal/fetcher"
+	"github.com/xxxbrian/surge-geosite/internal/server"
+)
+
+// newTestServer serves lists from a local data directory and returns the
+// server with its routes.
+func newTestServer(t *testing.T, cfg server.Config, lists map[string]string) (*server.Server, http.Handler) {
+	t.Helper()
+	up := newLocalSource(t, lists)
+	srv := server.NewServer(up, fetcher.NewGeoIPFetcher(""), cache.NewResultCache(time.Hour), cfg)
+	mux := http.NewServeMux()
+	srv.SetupRoutes(mux)
+	return srv, mux
+}
+
+// newLocalSource writes lists to a data directory and serves it.
+func newLocalSource(t *testing.T, lists map[string]string) *fetcher.LocalSource {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "data")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range lists {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	up, err := fetcher.NewLocalSource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return up
+}
+
+// get serves a GET request for target with the given headers.
+func get(handler http.Handler, target string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDomainSetResidualURL(t *testing.T) {
+	lists := map[string]string{"google": "google.com\nkeyword:google\n"}
+	_, handler := newTestServer(t, server.Config{}, lists)
+
+	for _, host := range []string{"evil.example", "geo.example"} {
+		rec := get(handler, "/geosite/surge-domainset/google", map[string]string{"X-Forwarded-Host": host})
+		want := "# 1 keyword/regex/IP rules are not supported by DOMAIN-SET\n# Add them with RULE-SET,/geosite/surge/google?residual=1\n.google.com"
+		if rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Errorf("Host %s: %d %q, want %q", host, rec.Code, rec.Body, want)
+		}
+		if got, want := rec.Header().Get("X-Geosite-Residual-URL"), "http://"+host+"/geosite/surge/google?residual=1"; got != want {
+			t.Errorf("Host %s: X-Geosite-Residual-URL = %q, want %q", host, got, want)
+		}
+	}
+	etag := snapshotETag(t, handler, "")
+	rec := get(handler, "/v/"+etag+"/geosite/surge-domainset/google", nil)
+	if want := "RULE-SET,/v/" + etag + "/geosite/surge/google?residual=1\n"; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("revision residual URL: %q, want %q", rec.Body, want)
+	}
+
+	_, handler = newTestServer(t, server.Config{BaseURL: "https://geo.example/"}, lists)
+	rec = get(handler, "/geosite/surge-domainset/google", map[string]string{"X-Forwarded-Host": "evil.example"})
+	if want := "RULE-SET,https://geo.example/geosite/surge/google?residual=1\n"; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("base URL residual URL: %q, want %q", rec.Body, want)
+	}
+	if got := rec.Header().Get("X-Geosite-Residual-URL"); got != "https://geo.example/geosite/surge/google?residual=1" {
+		t.Errorf("X-Geosite-Residual-URL = %q", got)
+	}
+}
+
+func TestRegistryRoutes(t *testing.T) {
+	render := func(items []converter.Item, _ converter.RenderOptions) (string, error) {
+		return "rendered\n", nil
+	}
+	converter.RegisterRenderer(converter.NewRenderer("route-domains", converter.ContentTypeText,
+		map[converter.RuleKind]converter.Support{converter.RuleDomainSuffix: converter.Native}, render))
+	converter.RegisterRenderer(converter.NewRenderer("route-ips", converter.ContentTypeText,
+		map[converter.RuleKind]converter.Support{converter.RuleIPCIDR: converter.Native}, render))
+	_, handler := newTestServer(t, server.Config{}, map[string]string{"google": "google.com\n"})
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/geosite/route-domains/google", http.StatusOK, "rendered"},
+		{"/geosite/route-domains", http.StatusOK, ""},
+		// A format without domain rules has no geosite prefix, so the path
+		// is read as a list name by /geosite/.
+		{"/geosite/route-ips/google", http.StatusInternalServerError, `invalid list name: "route-ips/google"`},
+		{"/geoip/route-ips/CN", http.StatusNotFound, "GeoIP code not found: CN\n"},
+		{"/geoip/route-domains/CN", http.StatusNotFound, "GeoIP code not found: route-domains/CN"},
+		{"/komari/route-ips/uuid", http.StatusServiceUnavailable, "Komari API not configured"},
+		{"/komari/route-domains/uuid", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := get(handler, tt.path, nil)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.path, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("%s: body %q, want it to contain %q", tt.path, rec.Body, tt.body)
+		}
+	}
+}
+
+func TestRulesetMetadata(t *testing.T) {
+	misc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("DOMAIN-SUFFIX,example.com\nIP-CIDR,1.2.3.0/24\nPROCESS-NAME,curl\n"))
+	}))
+	defer misc.Close()
+	_, handler := newTestServer(t, server.Config{MiscBaseURL: misc.URL}, map[string]string{"google": "google.com\n"})
+
+	tests := []struct {
+		path    string
+		headers map[string]string
+		body    string
+	}{
+		{"/geosite/google?meta=1", map[string]string{"X-Geosite-Name": "google", "X-Geosite-Skipped-Rules": "0"},
+			"# name: google\n# filter: none\n# upstream-etag: "},
+		// Misc lists are not read from a snapshot, so the upstream fields are
+		// left out; the PROCESS-NAME rule counts as skipped.
+		{"/misc/proxy/test?meta=1", map[string]string{"X-Geosite-Name": "proxy/test", "X-Geosite-Skipped-Rules": "1", "X-Geosite-Upstream-Etag": ""},
+			"# name: proxy/test\n# filter: none\n# generated: "},
+		{"/misc/proxy/test?format=mihomo-domain", map[string]string{"X-Geosite-Rules": "domain-suffix=1, domain=0, domain-keyword=0, domain-regex=0, ip-cidr=1", "X-Geosite-Skipped-Rules": "2"},
+			"# 1 rules skipped, not supported by the domain behavior:\n"},
+	}
+	for _, tt := range tests {
+		rec := get(handler, tt.path, nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d: %s", tt.path, rec.Code, rec.Body)
+			continue
+		}
+		for key, want := range tt.headers {
+			if got := rec.Header().Get(key); got != want {
+				t.Errorf("%s: %s = %q, want %q", tt.path, key, got, want)
+			}
+		}
+		if !strings.HasPrefix(rec.Body.String(), tt.body) {
+			t.Errorf("%s: body %q, want prefix %q", tt.path, rec.Body, tt.body)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Package singbox encodes sing-box rule-sets in source (JSON) and binary (.srs) form.
+package singbox
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/xxxbrian/surge-geosite/internal/ipset"
+	"github.com/xxxbrian/surge-geosite/internal/succinct"
+)
+
+var magicBytes = [3]byte{'S', 'R', 'S'}
+
+// Rule item types of the binary format.
+const (
+	ruleItemDomain        uint8 = 2
+	ruleItemDomainKeyword uint8 = 3
+	ruleItemDomainRegex   uint8 = 4
+	ruleItemIPCIDR        uint8 = 6
+	ruleItemFinal         uint8 = 0xFF
+)
+
+// suffixLabel terminates a reversed domain that matches any further labels.
+const suffixLabel = '\b'
+
+// MarshalBinary encodes the rule-set in sing-box binary (.srs) format.
+func (rs *RuleSet) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(magicBytes[:])
+	buf.WriteByte(Version)
+
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(zw)
+
+	ruleCount := 0
+	if !rs.IsEmpty() {
+		ruleCount = 1
+	}
+	writeUvarint(w, uint64(ruleCount))
+	if ruleCount > 0 {
+		if err := rs.writeRule(w); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (rs *RuleSet) writeRule(w *bufio.Writer) error {
+	// Rule type: default rule.
+	w.WriteByte(0)
+
+	if len(rs.Domain) > 0 || len(rs.DomainSuffix) > 0 {
+		w.WriteByte(ruleItemDomain)
+		writeDomainMatcher(w, rs.Domain, rs.DomainSuffix)
+	}
+	if len(rs.DomainKeyword) > 0 {
+		w.WriteByte(ruleItemDomainKeyword)
+		writeStrings(w, rs.DomainKeyword)
+	}
+	if len(rs.DomainRegex) > 0 {
+		w.WriteByte(ruleItemDomainRegex)
+		writeStrings(w, rs.DomainRegex)
+	}
+	if len(rs.IPCIDR) > 0 {
+		ranges, err := ipset.Ranges(rs.IPCIDR)
+		if err != nil {
+			return err
+		}
+		w.WriteByte(ruleItemIPCIDR)
+		writeIPSet(w, ranges)
+	}
+
+	w.WriteByte(ruleItemFinal)
+	// Invert flag.
+	w.WriteByte(0)
+	return nil
+}
+
+// writeDomainMatcher writes the succinct domain set. Each suffix is stored as
+// an exact match plus a "." suffix match, the encoding sing-box itself emits
+// for version 1 so that "example.com" does not match "notexample.com".
+func writeDomainMatcher(w *bufio.Writer, domains, suffixes []string) {
+	keys := make([]string, 0, len(domains)+2*len(suffixes))
+	seen := make(map[string]bool, cap(keys))
+	add := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, suffix := range suffixes {
+		add(reverseDomain(suffix))
+		add(reverseDomain("."+suffix) + string(suffixLabel))
+	}
+	for _, domain := range domains {
+		add(reverseDomain(domain))
+	}
+	sort.Strings(keys)
+
+	set := succinct.Build(keys)
+	// Succinct set version.
+	w.WriteByte(1)
+	writeUint64s(w, set.Leaves)
+	writeUint64s(w, set.LabelBitmap)
+	writeUvarint(w, uint64(len(set.Labels)))
+	w.Write(set.Labels)
+}
+
+func writeIPSet(w *bufio.Writer, ranges []ipset.Range) {
+	// IP set version.
+	w.WriteByte(1)
+	binary.Write(w, binary.BigEndian, uint64(len(ranges)))
+	for _, r := range ranges {
+		writeBytes(w, r.From.AsSlice())
+		writeBytes(w, r.To.AsSlice())
+	}
+}
+
+func writeStrings(w *bufio.Writer, values []string) {
+	writeUvarint(w, uint64(len(values)))
+	for _, value := range values {
+		writeBytes(w, []byte(value))
+	}
+}
+
+func writeUint64s(w *bufio.Writer, values []uint64) {
+	writeUvarint(w, uint64(len(values)))
+	for _, value := range values {
+		binary.Write(w, binary.BigEndian, value)
+	}
+}
+
+func writeBytes(w *bufio.Writer, value []byte) {
+	writeUvarint(w, uint64(len(value)))
+	w.Write(value)
+}
+
+func writeUvarint(w io.Writer, value uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], value)
+	w.Write(buf[:n])
+}
+
+func reverseDomain(domain string) string {
+	l := len(domain)
+	b := make([]byte, l)
+	for i := 0; i < l; {
+		r, n := utf8.DecodeRuneInString(domain[i:])
+		i += n
+		utf8.EncodeRune(b[l-i:], r)
+	}
+	return string(b)
+}
@@ -0,0 +1,67 @@
+package singbox_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/xxxbrian/surge-geosite/internal/singbox"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	rs := &singbox.RuleSet{
+		DomainSuffix:  []string{"cn"},
+		DomainKeyword: []string{"x"},
+		IPCIDR:        []string{"10.0.0.0/8"},
+	}
+	want := []byte{
+		0x01,                      // rule count
+		0x00,                      // default rule
+		0x02,                      // domain item
+		0x01,                      // succinct set version
+		0x01,                      // leaves length
+		0, 0, 0, 0, 0, 0, 0, 0x14, // leaves: "nc", "nc.\b"
+		0x01,                         // label bitmap length
+		0, 0, 0, 0, 0, 0, 0x01, 0xaa, // label bitmap
+		0x04, 'n', 'c', '.', '\b', // labels
+		0x03,            // domain keyword item
+		0x01, 0x01, 'x', // keywords
+		0x06,                   // ip cidr item
+		0x01,                   // ip set version
+		0, 0, 0, 0, 0, 0, 0, 1, // range count
+		0x04, 10, 0, 0, 0, // from
+		0x04, 10, 255, 255, 255, // to
+		0xff, // final item
+		0x00, // not inverted
+	}
+	if got := binaryPayload(t, rs); !bytes.Equal(got, want) {
+		t.Errorf("MarshalBinary() payload = %x, want %x", got, want)
+	}
+
+	if got := binaryPayload(t, &singbox.RuleSet{}); !bytes.Equal(got, []byte{0x00}) {
+		t.Errorf("MarshalBinary() of an empty rule-set = %x, want 00", got)
+	}
+}
+
+// binaryPayload encodes rs, checks the header and returns the
+// decompressed payload.
+func binaryPayload(t *testing.T, rs *singbox.RuleSet) []byte {
+	t.Helper()
+	data, err := rs.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header := []byte{'S', 'R', 'S', singbox.Version}; !bytes.HasPrefix(data, header) {
+		t.Fatalf("MarshalBinary() header = %x, want %x", data[:min(len(data), 4)], header)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data[4:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
@@ -0,0 +1,49 @@
+// Package singbox encodes sing-box rule-sets in source (JSON) and binary (.srs) form.
+package singbox
+
+import "encoding/json"
+
+// Version is the rule-set version written to both formats. Version 1 is
+// understood by every sing-box release that supports rule-sets.
+const Version = 1
+
+// RuleSet holds the items of a single headless rule. Items of different kinds
+// are ORed together by sing-box.
+type RuleSet struct {
+	Domain        []string
+	DomainSuffix  []string
+	DomainKeyword []string
+	DomainRegex   []string
+	IPCIDR        []string
+}
+
+type headlessRule struct {
+	Domain        []string `json:"domain,omitempty"`
+	DomainSuffix  []string `json:"domain_suffix,omitempty"`
+	DomainKeyword []string `json:"domain_keyword,omitempty"`
+	DomainRegex   []string `json:"domain_regex,omitempty"`
+	IPCIDR        []string `json:"ip_cidr,omitempty"`
+}
+
+type source struct {
+	Version int            `json:"version"`
+	Rules   []headlessRule `json:"rules"`
+}
+
+// IsEmpty reports whether the rule-set has no items at all.
+func (rs *RuleSet) IsEmpty() bool {
+	return len(rs.Domain) == 0 && len(rs.DomainSuffix) == 0 && len(rs.DomainKeyword) == 0 &&
+		len(rs.DomainRegex) == 0 && len(rs.IPCIDR) == 0
+}
+
+// MarshalSource encodes the rule-set in sing-box source format.
+func (rs *RuleSet) MarshalSource() ([]byte, error) {
+	src := source{
+		Version: Version,
+		Rules:   []headlessRule{},
+	}
+	if !rs.IsEmpty() {
+		src.Rules = append(src.Rules, headlessRule(*rs))
+	}
+	return json.MarshalIndent(src, "", "  ")
+}
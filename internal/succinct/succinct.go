@@ -0,0 +1,55 @@
+// Package succinct builds the level-order succinct trie used by the binary
+// domain sets of sing-box (.srs) and mihomo (.mrs) rule-sets.
+package succinct
+
+// Set is a succinct trie over a sorted list of keys.
+type Set struct {
+	Leaves      []uint64
+	LabelBitmap []uint64
+	Labels      []byte
+}
+
+// Build creates a Set from keys, which must be sorted and free of duplicates.
+func Build(keys []string) *Set {
+	set := &Set{}
+	if len(keys) == 0 {
+		return set
+	}
+
+	type element struct{ start, end, col int }
+	queue := []element{{0, len(keys), 0}}
+	labelIndex := 0
+
+	for i := 0; i < len(queue); i++ {
+		elt := queue[i]
+		if elt.col == len(keys[elt.start]) {
+			// The key ending here marks the node as a leaf.
+			elt.start++
+			setBit(&set.Leaves, i)
+		}
+		for j := elt.start; j < elt.end; {
+			from := j
+			for ; j < elt.end && keys[j][elt.col] == keys[from][elt.col]; j++ {
+			}
+			queue = append(queue, element{from, j, elt.col + 1})
+			set.Labels = append(set.Labels, keys[from][elt.col])
+			growBitmap(&set.LabelBitmap, labelIndex)
+			labelIndex++
+		}
+		setBit(&set.LabelBitmap, labelIndex)
+		labelIndex++
+	}
+
+	return set
+}
+
+func growBitmap(bitmap *[]uint64, i int) {
+	for i>>6 >= len(*bitmap) {
+		*bitmap = append(*bitmap, 0)
+	}
+}
+
+func setBit(bitmap *[]uint64, i int) {
+	growBitmap(bitmap, i)
+	(*bitmap)[i>>6] |= 1 << uint(i&63)
+}
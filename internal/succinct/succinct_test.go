@@ -0,0 +1,97 @@
+package succinct_test
+
+import (
+	"bytes"
+	"math/bits"
+	"slices"
+	"testing"
+
+	"github.com/xxxbrian/surge-geosite/internal/succinct"
+)
+
+func TestBuild(t *testing.T) {
+	set := succinct.Build([]string{"a", "ab", "b"})
+
+	// Nodes in level order: root, "a", "b", "ab"; all but the root are keys.
+	if want := []uint64{0b1110}; !slices.Equal(set.Leaves, want) {
+		t.Errorf("Leaves = %b, want %b", set.Leaves, want)
+	}
+	// Children of each node followed by a one: root "a" "b", "a" "b", "b", "ab".
+	if want := []uint64{0b1110100}; !slices.Equal(set.LabelBitmap, want) {
+		t.Errorf("LabelBitmap = %b, want %b", set.LabelBitmap, want)
+	}
+	if want := []byte("abb"); !bytes.Equal(set.Labels, want) {
+		t.Errorf("Labels = %q, want %q", set.Labels, want)
+	}
+
+	if empty := succinct.Build(nil); empty.Leaves != nil || empty.LabelBitmap != nil || empty.Labels != nil {
+		t.Errorf("Build(nil) = %+v, want an empty set", empty)
+	}
+}
+
+func TestBuildLookup(t *testing.T) {
+	keys := []string{"moc.elgoog", "moc.elgoog.", "moc.elgoog.www", "nc", "nc.", "ten.elgoog"}
+	set := succinct.Build(keys)
+	for _, key := range keys {
+		if !contains(set, key) {
+			t.Errorf("set does not contain %q", key)
+		}
+	}
+	for _, key := range []string{"", "moc", "moc.elgoo", "moc.elgoog.w", "ncx", "ten"} {
+		if contains(set, key) {
+			t.Errorf("set contains %q", key)
+		}
+	}
+}
+
+// contains walks the trie the way sing-box and mihomo match domains.
+func contains(set *succinct.Set, key string) bool {
+	node, pos := 0, 0
+	for i := 0; i < len(key); i++ {
+		for ; ; pos++ {
+			if bit(set.LabelBitmap, pos) {
+				return false
+			}
+			if set.Labels[pos-node] == key[i] {
+				break
+			}
+		}
+		node = pos + 1 - ones(set.LabelBitmap, pos+1)
+		pos = selectOne(set.LabelBitmap, node-1) + 1
+	}
+	return bit(set.Leaves, node)
+}
+
+func bit(bitmap []uint64, i int) bool {
+	return i>>6 < len(bitmap) && bitmap[i>>6]&(1<<uint(i&63)) != 0
+}
+
+// ones counts the set bits before position n.
+func ones(bitmap []uint64, n int) int {
+	count := 0
+	for i := 0; i < n; i++ {
+		if bit(bitmap, i) {
+			count++
+		}
+	}
+	return count
+}
+
+// selectOne returns the position of the set bit with index i.
+func selectOne(bitmap []uint64, i int) int {
+	for word, value := range bitmap {
+		if n := bits.OnesCount64(value); i >= n {
+			i -= n
+			continue
+		}
+		for pos := 0; ; pos++ {
+			if value&(1<<uint(pos)) != 0 {
+				if i == 0 {
+					return word<<6 + pos
+				}
+				i--
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,94 @@
+// Package v2ray encodes geosite.dat files in the v2ray/xray protobuf format.
+package v2ray
+
+import "encoding/binary"
+
+// DomainType is the match type of a domain entry.
+type DomainType int
+
+// Domain types as numbered in v2ray's router config.
+const (
+	// DomainPlain matches when the value occurs anywhere in the domain.
+	DomainPlain DomainType = 0
+	DomainRegex DomainType = 1
+	// DomainRoot matches the domain and all of its subdomains.
+	DomainRoot DomainType = 2
+	DomainFull DomainType = 3
+)
+
+// Domain is a single entry of a geosite list.
+type Domain struct {
+	Type  DomainType
+	Value string
+	// Attrs are stored as boolean attributes, selectable as "geosite:name@attr".
+	Attrs []string
+}
+
+// GeoSite is a named list of domains.
+type GeoSite struct {
+	CountryCode string
+	Domains     []Domain
+}
+
+// Protobuf field numbers of the GeoSiteList message tree.
+const (
+	fieldGeoSiteListEntry   = 1
+	fieldGeoSiteCode        = 1
+	fieldGeoSiteDomain      = 2
+	fieldDomainType         = 1
+	fieldDomainValue        = 2
+	fieldDomainAttribute    = 3
+	fieldAttributeKey       = 1
+	fieldAttributeBoolValue = 2
+)
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// MarshalGeoSiteList encodes sites as a GeoSiteList message, the content of
+// a geosite.dat file.
+func MarshalGeoSiteList(sites []GeoSite) []byte {
+	var buf []byte
+	for _, site := range sites {
+		buf = appendBytesField(buf, fieldGeoSiteListEntry, marshalGeoSite(site))
+	}
+	return buf
+}
+
+func marshalGeoSite(site GeoSite) []byte {
+	var buf []byte
+	buf = appendBytesField(buf, fieldGeoSiteCode, []byte(site.CountryCode))
+	for _, domain := range site.Domains {
+		buf = appendBytesField(buf, fieldGeoSiteDomain, marshalDomain(domain))
+	}
+	return buf
+}
+
+func marshalDomain(domain Domain) []byte {
+	var buf []byte
+	// proto3 omits fields holding their default value.
+	if domain.Type != DomainPlain {
+		buf = appendVarintField(buf, fieldDomainType, uint64(domain.Type))
+	}
+	buf = appendBytesField(buf, fieldDomainValue, []byte(domain.Value))
+	for _, attr := range domain.Attrs {
+		var attribute []byte
+		attribute = appendBytesField(attribute, fieldAttributeKey, []byte(attr))
+		attribute = appendVarintField(attribute, fieldAttributeBoolValue, 1)
+		buf = appendBytesField(buf, fieldDomainAttribute, attribute)
+	}
+	return buf
+}
+
+func appendVarintField(buf []byte, field int, value uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|wireVarint)
+	return binary.AppendUvarint(buf, value)
+}
+
+func appendBytesField(buf []byte, field int, value []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
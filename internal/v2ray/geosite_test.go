@@ -0,0 +1,34 @@
+package v2ray_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xxxbrian/surge-geosite/internal/v2ray"
+)
+
+func TestMarshalGeoSiteList(t *testing.T) {
+	got := v2ray.MarshalGeoSiteList([]v2ray.GeoSite{{
+		CountryCode: "CN",
+		Domains: []v2ray.Domain{
+			{Type: v2ray.DomainPlain, Value: "a"},
+			{Type: v2ray.DomainRoot, Value: "b.cn", Attrs: []string{"ads"}},
+		},
+	}})
+
+	want := []byte{
+		0x0a, 0x1c, // GeoSiteList.entry
+		0x0a, 0x02, 'C', 'N', // GeoSite.country_code
+		0x12, 0x03, // GeoSite.domain
+		0x12, 0x01, 'a', // Domain.value, type Plain omitted
+		0x12, 0x11, // GeoSite.domain
+		0x08, 0x02, // Domain.type = RootDomain
+		0x12, 0x04, 'b', '.', 'c', 'n', // Domain.value
+		0x1a, 0x07, // Domain.attribute
+		0x0a, 0x03, 'a', 'd', 's', // Attribute.key
+		0x10, 0x01, // Attribute.bool_value
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("MarshalGeoSiteList() = %x, want %x", got, want)
+	}
+}
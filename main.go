@@ -4,6 +4,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -11,7 +12,10 @@ import (
 	"time"
 
 	"github.com/xxxbrian/surge-geosite/internal/cache"
+	"github.com/xxxbrian/surge-geosite/internal/converter"
 	"github.com/xxxbrian/surge-geosite/internal/fetcher"
+	"github.com/xxxbrian/surge-geosite/internal/httpclient"
+	"github.com/xxxbrian/surge-geosite/internal/overlay"
 	"github.com/xxxbrian/surge-geosite/internal/server"
 )
 
@@ -25,15 +29,58 @@ func main() {
 	zipTTL := flag.Duration("zip-ttl", 30*time.Minute, "ZIP cache TTL")
 	resultTTL := flag.Duration("result-ttl", 24*time.Hour, "Result cache TTL")
 	zipCachePath := flag.String("zip-cache-path", "", "ZIP cache persistence file path (optional)")
+	zipHistory := flag.Int("zip-history", 5, "Past ZIP snapshots kept for ?rev= and rollback")
 	refreshInterval := flag.Duration("zip-refresh-interval", 30*time.Minute, "Interval to refresh ZIP cache (0 to disable)")
 	komariAPIKey := flag.String("komari-api-key", envOrDefault("KOMARI_API_KEY", ""), "Komari API key for IP CIDR ruleset")
 	komariBaseURL := flag.String("komari-base-url", envOrDefault("KOMARI_BASE_URL", ""), "Komari API base URL (e.g. https://komari.example.com)")
 	komariPathUUID := flag.String("komari-path-uuid", envOrDefault("KOMARI_PATH_UUID", ""), "Optional UUID prefix for Komari path (e.g. 550e8400-e29b-41d4-a716-446655440000)")
 	geoipURL := flag.String("geoip-url", envOrDefault("GEO_DB_URL", ""), "MaxMind GeoIP DB download URL")
+	buildDat := flag.String("build-geosite-dat", "", "Build a geosite.dat to this path and exit (CLI mode)")
+	datLists := flag.String("dat-lists", "", "Comma-separated lists to include in -build-geosite-dat")
+	upstreamURL := flag.String("upstream", envOrDefault("GEO_UPSTREAM", fetcher.DefaultZipURL), "Default upstream: archive URL, GitHub owner/repo[@ref] or dir:<local checkout>")
+	var sources sourceFlags
+	flag.Var(&sources, "source", "Extra upstream served under /geosite/@name/, as name=upstream[,ttl=30m][,refresh=30m][,timeout=60s][,cache=path] (repeatable)")
+	pin := flag.String("pin", envOrDefault("GEO_PIN", ""), "Pin the default upstream to a tag, commit or archive URL (optional)")
+	rollback := flag.Bool("rollback", false, "Restore the previous ZIP snapshot from -zip-cache-path, pin it and exit (CLI mode)")
+	adminToken := flag.String("admin-token", envOrDefault("GEO_ADMIN_TOKEN", ""), "Bearer token enabling the /admin endpoints (optional)")
+	overlayDir := flag.String("overlay-dir", envOrDefault("GEO_OVERLAY_DIR", ""), "Directory of local v2fly-format lists adding to or patching upstream (optional)")
+	overlayInterval := flag.Duration("overlay-reload-interval", 10*time.Second, "Interval to check the overlay directory for changes (0 to disable)")
+	mirrors := flag.String("mirrors", envOrDefault("GEO_MIRRORS", ""), "Comma-separated mirrors of the default upstream archive, tried in order: prefix ending in /, URL containing {url}, or full archive URL (optional)")
+	var retry fetchOptions
+	flag.IntVar(&retry.attempts, "fetch-attempts", 3, "Attempts per archive URL before trying the next mirror")
+	flag.DurationVar(&retry.backoff, "fetch-backoff", time.Second, "Delay before the first retry of a download, doubling with each retry")
+	flag.IntVar(&retry.minLists, "min-lists", 10, "Lists a downloaded archive must contain to replace the cached one")
+	var httpConfig httpclient.Config
+	flag.StringVar(&httpConfig.Proxy, "http-proxy", envOrDefault("GEO_HTTP_PROXY", ""), "Proxy for outbound requests: http://, https:// or socks5:// URL (default: HTTP_PROXY/HTTPS_PROXY)")
+	flag.StringVar(&httpConfig.CAFile, "http-ca-file", envOrDefault("GEO_HTTP_CA_FILE", ""), "PEM CA bundle trusted for outbound requests in addition to system roots (optional)")
+	flag.StringVar(&httpConfig.CertFile, "http-client-cert", envOrDefault("GEO_HTTP_CLIENT_CERT", ""), "PEM client certificate for outbound requests (optional)")
+	flag.StringVar(&httpConfig.KeyFile, "http-client-key", envOrDefault("GEO_HTTP_CLIENT_KEY", ""), "PEM key of -http-client-cert (optional)")
+	flag.StringVar(&httpConfig.UserAgent, "user-agent", envOrDefault("GEO_USER_AGENT", httpclient.DefaultUserAgent), "User-Agent of outbound requests")
+	upstreamTimeout := flag.Duration("upstream-timeout", 60*time.Second, "Timeout of upstream archive downloads")
+	geoipTimeout := flag.Duration("geoip-timeout", 60*time.Second, "Timeout of GeoIP DB downloads")
+	miscTimeout := flag.Duration("misc-timeout", 30*time.Second, "Timeout of misc list requests")
+	komariTimeout := flag.Duration("komari-timeout", 30*time.Second, "Timeout of Komari API requests")
 	flag.Parse()
+	if env := os.Getenv("GEO_SOURCES"); env != "" && len(sources) == 0 {
+		for _, value := range strings.Split(env, ";") {
+			if strings.TrimSpace(value) == "" {
+				continue
+			}
+			if err := sources.Set(value); err != nil {
+				log.Fatalf("Invalid GEO_SOURCES: %v", err)
+			}
+		}
+	}
+
+	transport, err := httpclient.NewTransport(httpConfig)
+	if err != nil {
+		log.Fatalf("Invalid outbound HTTP configuration: %v", err)
+	}
+	retry.client = httpclient.New(transport, *upstreamTimeout)
 
 	// Initialize caches
 	zipCache := cache.NewZipCache(*zipTTL)
+	zipCache.SetHistorySize(*zipHistory)
 	resultCache := cache.NewResultCache(*resultTTL)
 	if *zipCachePath != "" {
 		zipCache.SetPersistPath(*zipCachePath)
@@ -47,8 +94,47 @@ func main() {
 	}
 
 	// Initialize fetcher
-	f := fetcher.NewFetcher(zipCache)
+	var ov *overlay.Overlay
+	if *overlayDir != "" {
+		var err error
+		ov, err = overlay.New(*overlayDir)
+		if err != nil {
+			log.Fatalf("Failed to load overlay from %s: %v", *overlayDir, err)
+		}
+	}
+	defaultOptions := retry
+	defaultOptions.overlay = ov
+	defaultOptions.mirrors = splitList(*mirrors)
+	f, err := newUpstream(*upstreamURL, zipCache, defaultOptions)
+	if err != nil {
+		log.Fatalf("Failed to open upstream %s: %v", *upstreamURL, err)
+	}
 	gf := fetcher.NewGeoIPFetcher(*geoipURL)
+	gf.SetHTTPClient(httpclient.New(transport, *geoipTimeout))
+
+	if *rollback {
+		if *zipCachePath == "" {
+			log.Fatalf("-rollback requires -zip-cache-path")
+		}
+		etag, err := f.Rollback()
+		if err != nil {
+			log.Fatalf("Failed to roll back: %v", err)
+		}
+		log.Printf("Rolled back to ETag %s, pinned as %s", etag, f.Pinned())
+		return
+	}
+	if *pin != "" && *pin != f.Pinned() {
+		if err := f.Pin(*pin); err != nil {
+			log.Fatalf("Failed to pin upstream to %s: %v", *pin, err)
+		}
+	}
+
+	if *buildDat != "" {
+		if err := buildGeositeDat(f, *buildDat, *datLists); err != nil {
+			log.Fatalf("Failed to build geosite.dat: %v", err)
+		}
+		return
+	}
 
 	// Initialize server
 	srv := server.NewServer(f, gf, resultCache, server.Config{
@@ -59,7 +145,31 @@ func main() {
 		KomariAPIKey:   *komariAPIKey,
 		KomariBaseURL:  *komariBaseURL,
 		KomariPathUUID: *komariPathUUID,
+		AdminToken:     *adminToken,
+		MiscClient:     httpclient.New(transport, *miscTimeout),
+		KomariClient:   httpclient.New(transport, *komariTimeout),
 	})
+	var sourceUpstreams []fetcher.Upstream
+	for _, src := range sources {
+		sourceCache := cache.NewZipCache(src.ttl)
+		sourceCache.SetHistorySize(*zipHistory)
+		if src.cachePath != "" {
+			sourceCache.SetPersistPath(src.cachePath)
+			if err := sourceCache.LoadFromFile(src.cachePath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to load ZIP cache of source %s from %s: %v", src.name, src.cachePath, err)
+			}
+		}
+		sourceOptions := retry
+		if src.timeout > 0 {
+			sourceOptions.client = httpclient.New(transport, src.timeout)
+		}
+		up, err := newUpstream(src.url, sourceCache, sourceOptions)
+		if err != nil {
+			log.Fatalf("Failed to open source %s: %v", src.name, err)
+		}
+		sourceUpstreams = append(sourceUpstreams, up)
+		srv.AddSource(src.name, up)
+	}
 	if err := srv.RefreshIndex(); err != nil {
 		log.Printf("Index refresh failed: %v", err)
 	}
@@ -83,30 +193,27 @@ func main() {
 		}
 	}()
 
-	// Start ZIP refresh goroutine
+	// Start ZIP refresh goroutines
 	if *refreshInterval > 0 {
-		go func() {
-			refresh := func() {
-				beforeETag := zipCache.GetETag()
-				_, afterETag, err := f.RefreshZipReader()
-				if err != nil {
-					log.Printf("ZIP refresh failed: %v", err)
-					return
-				}
-				if afterETag != "" && afterETag != beforeETag {
-					log.Printf("ZIP cache refreshed (etag %s)", afterETag)
-				}
-				if err := srv.RefreshIndex(); err != nil {
-					log.Printf("Index refresh failed: %v", err)
-				}
+		go refreshUpstream("default", f, *refreshInterval, func() {
+			if err := srv.RefreshIndex(); err != nil {
+				log.Printf("Index refresh failed: %v", err)
 			}
-			refresh()
-			ticker := time.NewTicker(*refreshInterval)
-			defer ticker.Stop()
-			for range ticker.C {
-				refresh()
+		})
+	}
+	for i, src := range sources {
+		if src.refresh > 0 {
+			go refreshUpstream(src.name, sourceUpstreams[i], src.refresh, nil)
+		}
+	}
+
+	// Start overlay reload goroutine
+	if ov != nil && *overlayInterval > 0 {
+		go ov.Watch(*overlayInterval, func() {
+			if err := srv.RefreshIndex(); err != nil {
+				log.Printf("Index refresh failed: %v", err)
 			}
-		}()
+		})
 	}
 
 	// Start GeoIP refresh goroutine (every 24 hours)
@@ -138,6 +245,19 @@ func main() {
 	if *refreshInterval > 0 {
 		log.Printf("ZIP refresh interval: %v", *refreshInterval)
 	}
+	log.Printf("Upstream: %s", f.URL())
+	if httpConfig.Proxy != "" {
+		log.Printf("Outbound proxy: %s", httpConfig.Proxy)
+	}
+	if ref := f.Pinned(); ref != "" {
+		log.Printf("Upstream pinned to %s, skipping upstream checks", ref)
+	}
+	for _, src := range sources {
+		log.Printf("Source @%s: %s (ZIP cache TTL %v, refresh interval %v)", src.name, src.url, src.ttl, src.refresh)
+	}
+	if ov != nil {
+		log.Printf("Overlay directory: %s (%d lists)", *overlayDir, len(ov.Names()))
+	}
 	if *komariAPIKey != "" {
 		log.Printf("Komari API enabled for IP CIDR ruleset")
 	}
@@ -147,6 +267,151 @@ func main() {
 	}
 }
 
+// fetchOptions configures how an upstream archive is downloaded.
+type fetchOptions struct {
+	overlay  *overlay.Overlay
+	mirrors  []string
+	attempts int
+	backoff  time.Duration
+	minLists int
+	client   *http.Client
+}
+
+// newUpstream opens the upstream given by spec: "dir:<path>" for a local
+// checkout or data directory, otherwise an archive URL or GitHub
+// "owner/repo[@ref]" downloaded into zipCache.
+func newUpstream(spec string, zipCache *cache.ZipCache, opts fetchOptions) (fetcher.Upstream, error) {
+	if dir, ok := strings.CutPrefix(spec, "dir:"); ok {
+		local, err := fetcher.NewLocalSource(dir)
+		if err != nil {
+			return nil, err
+		}
+		if opts.overlay != nil {
+			local.SetOverlay(opts.overlay)
+		}
+		return local, nil
+	}
+	f := fetcher.NewFetcher(fetcher.ArchiveURL(spec), zipCache)
+	if opts.overlay != nil {
+		f.SetOverlay(opts.overlay)
+	}
+	f.SetMirrors(opts.mirrors)
+	f.SetRetry(opts.attempts, opts.backoff)
+	f.SetMinLists(opts.minLists)
+	if opts.client != nil {
+		f.SetHTTPClient(opts.client)
+	}
+	return f, nil
+}
+
+// refreshUpstream checks up for updates every interval, calling onRefresh
+// after each successful check. It never returns.
+func refreshUpstream(name string, up fetcher.Upstream, interval time.Duration, onRefresh func()) {
+	lastETag := ""
+	refresh := func() {
+		_, etag, err := up.Refresh()
+		if err != nil {
+			log.Printf("Refresh of %s upstream failed: %v", name, err)
+			return
+		}
+		if lastETag != "" && etag != lastETag {
+			log.Printf("Upstream %s refreshed (etag %s)", name, etag)
+		}
+		lastETag = etag
+		if onRefresh != nil {
+			onRefresh()
+		}
+	}
+	refresh()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		refresh()
+	}
+}
+
+// sourceConfig configures an extra upstream given by -source.
+type sourceConfig struct {
+	name      string
+	url       string
+	ttl       time.Duration
+	refresh   time.Duration
+	timeout   time.Duration
+	cachePath string
+}
+
+// sourceFlags collects repeated -source flags.
+type sourceFlags []sourceConfig
+
+func (s *sourceFlags) String() string {
+	names := make([]string, 0, len(*s))
+	for _, src := range *s {
+		names = append(names, src.name)
+	}
+	return strings.Join(names, ",")
+}
+
+// Set parses "name=upstream[,ttl=30m][,refresh=30m][,timeout=60s][,cache=path]",
+// where upstream is given as for -upstream.
+func (s *sourceFlags) Set(value string) error {
+	fields := strings.Split(strings.TrimSpace(value), ",")
+	name, spec, ok := strings.Cut(fields[0], "=")
+	name = strings.ToLower(strings.TrimSpace(name))
+	spec = strings.TrimSpace(spec)
+	if !ok || name == "" || spec == "" || strings.ContainsAny(name, "/@+") {
+		return fmt.Errorf("invalid source %q, expected name=url", value)
+	}
+	for _, src := range *s {
+		if src.name == name {
+			return fmt.Errorf("duplicate source %q", name)
+		}
+	}
+	src := sourceConfig{name: name, url: spec, ttl: 30 * time.Minute, refresh: 30 * time.Minute}
+	for _, field := range fields[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(field), "=")
+		var err error
+		switch key {
+		case "ttl":
+			src.ttl, err = time.ParseDuration(val)
+		case "refresh":
+			src.refresh, err = time.ParseDuration(val)
+		case "timeout":
+			src.timeout, err = time.ParseDuration(val)
+		case "cache":
+			src.cachePath = val
+		default:
+			err = fmt.Errorf("unknown option %q", key)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid source %q: %w", name, err)
+		}
+	}
+	*s = append(*s, src)
+	return nil
+}
+
+// buildGeositeDat writes a geosite.dat containing the given lists to path.
+func buildGeositeDat(up fetcher.Upstream, path string, lists string) error {
+	names, err := converter.ParseListNames(lists, ",")
+	if err != nil {
+		return err
+	}
+	// Lists would serve an expired snapshot while checking in the background.
+	snapshot, etag, err := up.Refresh()
+	if err != nil {
+		return err
+	}
+	data, err := converter.NewConverter(snapshot).BuildGeoSiteDat(names)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	log.Printf("Wrote %d lists to %s (ETag %s)", len(names), path, etag)
+	return nil
+}
+
 func envOrDefault(key string, def string) string {
 	value := strings.TrimSpace(os.Getenv(key))
 	if value == "" {
@@ -154,3 +419,14 @@ func envOrDefault(key string, def string) string {
 	}
 	return value
 }
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}